@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	EnvGithubActions     = "GITHUB_ACTIONS"
+	EnvGithubOutput      = "GITHUB_OUTPUT"
+	EnvGithubStepSummary = "GITHUB_STEP_SUMMARY"
+)
+
+// runnerEnvs are provided by the runner itself and have no action input
+var runnerEnvs = map[string]bool{
+	EnvGithubRefName:         true,
+	EnvGithubRepository:      true,
+	EnvGithubRepositoryOwner: true,
+	EnvGithubEventName:       true,
+	EnvGithubEventPath:       true,
+}
+
+// inputName returns the name of the action input for a setting, e.g. "tf-token" for TF_TOKEN
+func inputName(envName string) string {
+	return strings.ReplaceAll(strings.ToLower(envName), "_", "-")
+}
+
+// lookupInput returns the value of the action input for a setting.  The runner exposes inputs as INPUT_<NAME>, with
+// every declared input present, so an empty input is treated as unset.
+func lookupInput(envName string) string {
+	if runnerEnvs[envName] {
+		return ""
+	}
+	return strings.TrimSpace(os.Getenv("INPUT_" + strings.ToUpper(inputName(envName))))
+}
+
+// settingName describes where a setting can be provided, for use in error messages
+func settingName(envName string) string {
+	if runnerEnvs[envName] {
+		return fmt.Sprintf("environment variable %q", envName)
+	}
+	return fmt.Sprintf("input %q or environment variable %q", inputName(envName), envName)
+}
+
+// annotateError prints msg, redacted, as an error annotation when run by a workflow, and as plain text otherwise
+func annotateError(title, msg string) {
+	if os.Getenv(EnvGithubActions) != "true" {
+		fmt.Println(redact(msg))
+		return
+	}
+	fmt.Printf("::error title=%s::%s\n", escapeCommandProperty(title), escapeCommandData(redact(msg)))
+}
+
+func escapeCommandData(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+func escapeCommandProperty(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C").Replace(s)
+}
+
+// setOutput writes a single step output, redacted, to the file referenced by GITHUB_OUTPUT.  Outside of a workflow run
+// the variable is not set and the output is silently dropped.
+func setOutput(name, value string) error {
+	fname := os.Getenv(EnvGithubOutput)
+	if fname == "" {
+		return nil
+	}
+
+	if strings.ContainsAny(value, "\r\n") {
+		return fmt.Errorf("output %q value must not contain newlines", name)
+	}
+
+	f, err := os.OpenFile(fname, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening %s file: %w", EnvGithubOutput, err)
+	}
+	defer func() { _ = f.Close() }()
+
+	if _, err = fmt.Fprintf(f, "%s=%s\n", name, redact(value)); err != nil {
+		return fmt.Errorf("error writing output %q: %w", name, err)
+	}
+
+	return nil
+}
+
+// appendStepSummary appends markdown to the job summary file referenced by GITHUB_STEP_SUMMARY.  Like setOutput, it
+// is a no-op outside a workflow run.
+func appendStepSummary(markdown string) error {
+	fname := os.Getenv(EnvGithubStepSummary)
+	if fname == "" {
+		return nil
+	}
+
+	f, err := os.OpenFile(fname, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening %s file: %w", EnvGithubStepSummary, err)
+	}
+	defer func() { _ = f.Close() }()
+
+	if _, err = f.WriteString(redact(markdown)); err != nil {
+		return fmt.Errorf("error writing job summary: %w", err)
+	}
+
+	return nil
+}
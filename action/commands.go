@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	CommandPublish = "publish"
+	CommandGPGKey  = "gpg-key"
+	CommandModule  = "module"
+	CommandDelete  = "delete"
+	CommandLint    = "lint"
+	CommandPlan    = "plan"
+	CommandConfig  = "config"
+)
+
+// commandFunc is the signature shared by all commands.  The result of execution must be sent on done.
+type commandFunc func(ctx context.Context, done chan<- error, log zerolog.Logger, cfg *Config)
+
+type command struct {
+	run commandFunc
+
+	// requiredEnvs returns the list of environment variables that must have a value for this command
+	requiredEnvs func(cfg *Config) []string
+
+	// targeted commands are run against every target in TF_TARGETS, the others only use the top-level settings
+	targeted bool
+}
+
+var commands = map[string]command{
+	CommandPublish: {
+		run:          run,
+		requiredEnvs: publishRequiredEnvs,
+		targeted:     true,
+	},
+	CommandGPGKey: {
+		run:          runGPGKey,
+		requiredEnvs: gpgKeyRequiredEnvs,
+	},
+	CommandModule: {
+		run:          runModule,
+		requiredEnvs: moduleRequiredEnvs,
+	},
+	CommandDelete: {
+		run:          runDelete,
+		requiredEnvs: deleteRequiredEnvs,
+		targeted:     true,
+	},
+	CommandLint: {
+		run:          runLint,
+		requiredEnvs: lintRequiredEnvs,
+	},
+	CommandPlan: {
+		run:          runPlan,
+		requiredEnvs: publishRequiredEnvs,
+		targeted:     true,
+	},
+	CommandConfig: {
+		run:          runConfig,
+		requiredEnvs: configRequiredEnvs,
+	},
+}
+
+// parseCommand splits the command name from its arguments.  Without arguments, the provider is published.
+//
+// When run as an action the full command line is provided as a single argument, so it is split on whitespace.
+func parseCommand(args []string) (string, []string) {
+	if len(args) == 1 {
+		args = strings.Fields(args[0])
+	}
+	if len(args) == 0 {
+		return CommandPublish, nil
+	}
+	return args[0], args[1:]
+}
+
+func commandNames() []string {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func publishRequiredEnvs(cfg *Config) []string {
+	envs := []string{
+		EnvGithubRefName,
+		EnvGithubRequestTTL,
+		EnvGithubDownloadTTL,
+		EnvTFAddress,
+		EnvTFToken,
+		EnvTFGPGKeyID,
+		EnvTFRegistryName,
+		EnvTFOrganizationName,
+		EnvTFRequestTTL,
+		EnvTFUploadTTL,
+	}
+
+	envs = append(envs, providerEnvs(cfg)...)
+
+	// the github api is only used when assets are not read from a local dist directory
+	if cfg.DistDir == "" {
+		envs = append(envs, EnvGithubToken, EnvGithubRepository, EnvGithubRepositoryOwner)
+	}
+
+	return envs
+}
+
+func moduleRequiredEnvs(cfg *Config) []string {
+	envs := []string{
+		EnvGithubRefName,
+		EnvGithubRequestTTL,
+		EnvGithubDownloadTTL,
+		EnvTFAddress,
+		EnvTFToken,
+		EnvTFRegistryName,
+		EnvTFOrganizationName,
+		EnvTFNamespace,
+		EnvTFModuleName,
+		EnvTFModuleProvider,
+		EnvTFRequestTTL,
+		EnvTFUploadTTL,
+	}
+
+	if cfg.DistDir == "" {
+		envs = append(envs, EnvGithubToken, EnvGithubRepository, EnvGithubRepositoryOwner)
+	}
+
+	return envs
+}
+
+func lintRequiredEnvs(cfg *Config) []string {
+	envs := []string{
+		EnvGithubRefName,
+		EnvGithubRequestTTL,
+		EnvGithubDownloadTTL,
+	}
+
+	// the namespace is not needed to lint
+	if cfg.TFProviders != "" {
+		envs = append(envs, EnvTFProviders)
+	} else {
+		envs = append(envs, EnvTFProviderName)
+	}
+
+	if cfg.DistDir == "" {
+		envs = append(envs, EnvGithubToken, EnvGithubRepository, EnvGithubRepositoryOwner)
+	}
+
+	return envs
+}
+
+func deleteRequiredEnvs(cfg *Config) []string {
+	envs := []string{
+		EnvGithubRefName,
+		EnvTFAddress,
+		EnvTFToken,
+		EnvTFRegistryName,
+		EnvTFOrganizationName,
+		EnvTFRequestTTL,
+	}
+
+	return append(envs, providerEnvs(cfg)...)
+}
+
+func gpgKeyRequiredEnvs(_ *Config) []string {
+	return []string{
+		EnvTFAddress,
+		EnvTFToken,
+		EnvTFNamespace,
+		EnvTFRequestTTL,
+	}
+}
+
+func configRequiredEnvs(_ *Config) []string {
+	return nil
+}
+
+// providerEnvs returns the settings naming the providers to publish.  With TF_PROVIDERS the namespace is only needed
+// if a provider is listed without one.
+func providerEnvs(cfg *Config) []string {
+	if cfg.TFProviders == "" {
+		return []string{EnvTFNamespace, EnvTFProviderName}
+	} else if len(cfg.tfProviders) == 0 || cfg.providersHaveNamespaces() {
+		// an unparseable list is reported on its own
+		return []string{EnvTFProviders}
+	}
+	return []string{EnvTFProviders, EnvTFNamespace}
+}
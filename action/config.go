@@ -0,0 +1,497 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/rs/zerolog"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	EnvConfigFile = "CONFIG_FILE"
+
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceEnv     = "env"
+	SourceInput   = "input"
+
+	// configRedacted replaces the value of secrets when explaining the configuration
+	configRedacted = "(redacted)"
+)
+
+var (
+	// configFileDefaults are looked for in the working directory, which is the repository checkout when run as an
+	// action, when CONFIG_FILE is not set
+	configFileDefaults = []string{
+		".tfcloud-provider-push.yml",
+		".tfcloud-provider-push.yaml",
+		".tfcloud-provider-push.json",
+	}
+
+	TFOrganizationNameRe = regexp.MustCompile("^[A-Za-z0-9_-]+$")
+	TFProviderNameRe     = regexp.MustCompile("^[a-z0-9][a-z0-9-]*$")
+	TFModuleNameRe       = regexp.MustCompile("^[A-Za-z0-9][A-Za-z0-9_-]*$")
+	TFModuleProviderRe   = regexp.MustCompile("^[a-z0-9]+$")
+)
+
+// setting is a single configurable value, identified by its environment variable
+type setting struct {
+	env   string
+	value *string
+
+	// secret settings are redacted when explained and may not be stored in the config file
+	secret bool
+
+	// document settings hold YAML or JSON, and may be written as such in the config file
+	document bool
+}
+
+// settings returns every configurable value in the order they are documented
+func (c *Config) settings() []setting {
+	return []setting{
+		{env: EnvGithubToken, value: &c.GithubToken, secret: true},
+		{env: EnvGithubRefName, value: &c.GithubRefName},
+		{env: EnvGithubRepository, value: &c.GithubRepository},
+		{env: EnvGithubRepositoryOwner, value: &c.GithubRepositoryOwner},
+		{env: EnvGithubRequestTTL, value: &c.GithubRequestTTL},
+		{env: EnvGithubDownloadTTL, value: &c.GithubDownloadTTL},
+		{env: EnvGithubEventName, value: &c.GithubEventName},
+		{env: EnvGithubEventPath, value: &c.GithubEventPath},
+
+		{env: EnvDistDir, value: &c.DistDir},
+		{env: EnvReleaseTag, value: &c.ReleaseTag},
+		{env: EnvReleaseID, value: &c.ReleaseID},
+
+		{env: EnvLogLevel, value: &c.LogLevel},
+		{env: EnvLogFormat, value: &c.LogFormat},
+		{env: EnvLogHTTP, value: &c.LogHTTP},
+
+		{env: EnvTFAddress, value: &c.TFAddress},
+		{env: EnvTFToken, value: &c.TFToken, secret: true},
+		{env: EnvTFGPGKeyID, value: &c.TFGPGKeyID},
+		{env: EnvTFRegistryName, value: &c.TFRegistryName},
+		{env: EnvTFOrganizationName, value: &c.TFOrganizationName},
+		{env: EnvTFNamespace, value: &c.TFNamespace},
+		{env: EnvTFProviderName, value: &c.TFProviderName},
+		{env: EnvTFProviders, value: &c.TFProviders},
+		{env: EnvTFTargets, value: &c.TFTargets, document: true},
+		{env: EnvTFProviderPlatforms, value: &c.TFProviderPlatforms},
+		{env: EnvTFRequestTTL, value: &c.TFRequestTTL},
+		{env: EnvTFUploadTTL, value: &c.TFUploadTTL},
+		{env: EnvTFMaxRetries, value: &c.TFMaxRetries},
+		{env: EnvTFRetryWaitMin, value: &c.TFRetryWaitMin},
+		{env: EnvTFRetryWaitMax, value: &c.TFRetryWaitMax},
+		{env: EnvTFRateLimit, value: &c.TFRateLimit},
+		{env: EnvTFDownloadConcurrency, value: &c.TFDownloadConcurrency},
+		{env: EnvTFUploadConcurrency, value: &c.TFUploadConcurrency},
+		{env: EnvTFFailureMode, value: &c.TFFailureMode},
+		{env: EnvTFSpoolDir, value: &c.TFSpoolDir},
+		{env: EnvTFCreateProvider, value: &c.TFCreateProvider},
+		{env: EnvTFModuleName, value: &c.TFModuleName},
+		{env: EnvTFModuleProvider, value: &c.TFModuleProvider},
+		{env: EnvTFConfirmDelete, value: &c.TFConfirmDelete},
+		{env: EnvTFDeletePlatforms, value: &c.TFDeletePlatforms},
+		{env: EnvTFCACertFile, value: &c.TFCACertFile},
+		{env: EnvTFClientCertFile, value: &c.TFClientCertFile},
+		{env: EnvTFClientKeyFile, value: &c.TFClientKeyFile},
+		{env: EnvTFProxyURL, value: &c.TFProxyURL},
+		{env: EnvTFGPGPublicKeyFile, value: &c.TFGPGPublicKeyFile},
+		{env: EnvTFGPGPrivateKey, value: &c.TFGPGPrivateKey, secret: true},
+		{env: EnvTFGPGPrivateKeyFile, value: &c.TFGPGPrivateKeyFile},
+		{env: EnvTFGPGPassphrase, value: &c.TFGPGPassphrase, secret: true},
+		{env: EnvTFGPGPassphraseFile, value: &c.TFGPGPassphraseFile},
+	}
+}
+
+// value returns the current value of the setting with the given environment variable
+func (c *Config) value(envName string) string {
+	for _, s := range c.settings() {
+		if s.env == envName {
+			return *s.value
+		}
+	}
+	return ""
+}
+
+// loadConfig builds the configuration from, in increasing order of precedence, the defaults, the config file, the
+// environment and the action inputs.  Problems with the config file are returned once every layer is applied, so they
+// may be reported together with any other invalid value.
+func loadConfig() (*Config, error) {
+	cfg := defaultConfig()
+	cfg.sources = make(map[string]string)
+
+	settings := cfg.settings()
+	for _, s := range settings {
+		if *s.value != "" {
+			cfg.sources[s.env] = SourceDefault
+		}
+	}
+
+	// the location of the config file itself can only be provided by input or environment variable
+	if cfg.ConfigFile = lookupInput(EnvConfigFile); cfg.ConfigFile == "" {
+		cfg.ConfigFile = strings.TrimSpace(os.Getenv(EnvConfigFile))
+	}
+
+	fileValues, err := cfg.readConfigFile()
+
+	for _, s := range settings {
+		if v, ok := fileValues[s.env]; ok {
+			*s.value, cfg.sources[s.env] = v, SourceFile
+		}
+		if v := strings.TrimSpace(os.Getenv(s.env)); v != "" {
+			*s.value, cfg.sources[s.env] = v, SourceEnv
+		}
+		if v := lookupInput(s.env); v != "" {
+			*s.value, cfg.sources[s.env] = v, SourceInput
+		}
+	}
+
+	return cfg, err
+}
+
+// readConfigFile reads the config file, if any, returning its values by environment variable.  Keys are named after
+// the action inputs, e.g. "tf-organization-name".  JSON being a subset of YAML, both formats are read the same way.
+func (c *Config) readConfigFile() (map[string]string, error) {
+	fname := c.ConfigFile
+	if fname == "" {
+		for _, name := range configFileDefaults {
+			if _, err := os.Stat(name); err == nil {
+				fname = name
+				break
+			}
+		}
+		if fname == "" {
+			return nil, nil
+		}
+	}
+
+	b, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file: %w", err)
+	}
+
+	c.configFile = fname
+
+	var doc yaml.Node
+	if err = yaml.Unmarshal(b, &doc); err != nil {
+		return nil, fmt.Errorf("error parsing config file %q: %w", fname, err)
+	} else if len(doc.Content) == 0 {
+		return nil, nil
+	} else if doc.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("config file %q must contain a mapping of settings", fname)
+	}
+
+	keys := make(map[string]setting)
+	for _, s := range c.settings() {
+		if !runnerEnvs[s.env] {
+			keys[inputName(s.env)] = s
+		}
+	}
+
+	var (
+		mapping = doc.Content[0].Content
+		values  = make(map[string]string, len(mapping)/2)
+		merr    error
+	)
+
+	// mapping nodes alternate between keys and values
+	for i := 0; i+1 < len(mapping); i += 2 {
+		key, node := mapping[i].Value, mapping[i+1]
+		s, ok := keys[key]
+		if !ok {
+			merr = multierror.Append(merr, fmt.Errorf("config file %q has unknown key %q", fname, key))
+			continue
+		} else if s.secret {
+			merr = multierror.Append(merr, fmt.Errorf("config file %q must not contain secret %q, provide it as an input or with %q instead", fname, key, s.env))
+			continue
+		}
+
+		// scalars are used as written, so "6.0" is not turned into "6"
+		switch {
+		case s.document && node.Kind != yaml.ScalarNode:
+			b, err := yaml.Marshal(node)
+			if err != nil {
+				merr = multierror.Append(merr, fmt.Errorf("config file %q key %q could not be read: %w", fname, key, err))
+				continue
+			}
+			values[s.env] = string(b)
+		case node.Kind == yaml.ScalarNode:
+			values[s.env] = strings.TrimSpace(node.Value)
+		case node.Kind == yaml.SequenceNode:
+			items := make([]string, 0, len(node.Content))
+			for _, item := range node.Content {
+				items = append(items, strings.TrimSpace(item.Value))
+			}
+			values[s.env] = strings.Join(items, ",")
+		default:
+			merr = multierror.Append(merr, fmt.Errorf("config file %q key %q must be a string or a list of strings", fname, key))
+		}
+	}
+
+	return values, merr
+}
+
+// describeSetting names where the value of a setting came from, for use in error messages
+func (c *Config) describeSetting(envName string) string {
+	switch c.sources[envName] {
+	case SourceFile:
+		return fmt.Sprintf("key %q of config file %q", inputName(envName), c.configFile)
+	case SourceEnv:
+		return fmt.Sprintf("environment variable %q", envName)
+	case SourceInput:
+		return fmt.Sprintf("input %q", inputName(envName))
+	default:
+		return fmt.Sprintf("default of %q", envName)
+	}
+}
+
+// parse validates the raw values and populates the parsed fields.  Every invalid value is returned, not just the
+// first one.
+func (c *Config) parse() error {
+	var merr error
+
+	invalid := func(envName, format string, args ...interface{}) {
+		merr = multierror.Append(merr, fmt.Errorf("value %q of %s %s", c.value(envName), c.describeSetting(envName), fmt.Sprintf(format, args...)))
+	}
+
+	for _, d := range []struct {
+		env string
+		dst *time.Duration
+	}{
+		{EnvGithubRequestTTL, &c.githubRequestTTL},
+		{EnvGithubDownloadTTL, &c.githubDownloadTTL},
+		{EnvTFRequestTTL, &c.tfRequestTTL},
+		{EnvTFUploadTTL, &c.tfUploadTTL},
+		{EnvTFRetryWaitMin, &c.tfRetryWaitMin},
+		{EnvTFRetryWaitMax, &c.tfRetryWaitMax},
+	} {
+		var err error
+		if *d.dst, err = time.ParseDuration(c.value(d.env)); err != nil {
+			invalid(d.env, "is not parseable as time.Duration: %v", err)
+		} else if *d.dst <= 0 {
+			invalid(d.env, "must be greater than zero")
+		}
+	}
+
+	for _, b := range []struct {
+		env string
+		dst *bool
+	}{
+		{EnvTFCreateProvider, &c.tfCreateProvider},
+		{EnvTFConfirmDelete, &c.tfConfirmDelete},
+		{EnvLogHTTP, &c.logHTTP},
+	} {
+		var err error
+		if *b.dst, err = strconv.ParseBool(c.value(b.env)); err != nil {
+			invalid(b.env, "is not parseable as bool: %v", err)
+		}
+	}
+
+	if c.tfRetryWaitMin > c.tfRetryWaitMax && c.tfRetryWaitMax > 0 {
+		invalid(EnvTFRetryWaitMin, "must not be greater than %q", c.TFRetryWaitMax)
+	}
+
+	var err error
+	if c.tfMaxRetries, err = strconv.Atoi(c.TFMaxRetries); err != nil {
+		invalid(EnvTFMaxRetries, "is not parseable as int: %v", err)
+	} else if c.tfMaxRetries < 0 {
+		invalid(EnvTFMaxRetries, "must not be negative")
+	}
+
+	for _, n := range []struct {
+		env string
+		dst *int
+	}{
+		{EnvTFDownloadConcurrency, &c.tfDownloadConcurrency},
+		{EnvTFUploadConcurrency, &c.tfUploadConcurrency},
+	} {
+		if *n.dst, err = strconv.Atoi(c.value(n.env)); err != nil {
+			invalid(n.env, "is not parseable as int: %v", err)
+		} else if *n.dst < 1 {
+			invalid(n.env, "must be at least 1")
+		}
+	}
+
+	if c.TFFailureMode != FailureModeBestEffort && c.TFFailureMode != FailureModeFailFast {
+		invalid(EnvTFFailureMode, "must be either %q or %q", FailureModeBestEffort, FailureModeFailFast)
+	}
+
+	if c.tfRateLimit, err = strconv.ParseFloat(c.TFRateLimit, 64); err != nil {
+		invalid(EnvTFRateLimit, "is not parseable as float: %v", err)
+	} else if c.tfRateLimit < 0 {
+		invalid(EnvTFRateLimit, "must not be negative")
+	}
+
+	switch lvl := strings.ToLower(c.LogLevel); lvl {
+	case "trace", "debug", "info", "warn", "error":
+		c.logLevel, _ = zerolog.ParseLevel(lvl)
+	default:
+		invalid(EnvLogLevel, "must be one of %q, %q, %q, %q or %q", "trace", "debug", "info", "warn", "error")
+	}
+
+	// the github format is only understood by the runner
+	switch c.logFormat = strings.ToLower(c.LogFormat); c.logFormat {
+	case LogFormatConsole, LogFormatJSON, LogFormatGithub:
+	case LogFormatAuto:
+		c.logFormat = LogFormatConsole
+		if os.Getenv(EnvGithubActions) == "true" {
+			c.logFormat = LogFormatGithub
+		}
+	default:
+		invalid(EnvLogFormat, "must be one of %q, %q, %q or %q", LogFormatAuto, LogFormatConsole, LogFormatJSON, LogFormatGithub)
+	}
+
+	// the protocol versions are preferably read from the release manifest, this is only an override
+	c.tfProviderPlatforms = nil
+	if c.TFProviderPlatforms != "" {
+		for _, p := range strings.Split(c.TFProviderPlatforms, ",") {
+			p = strings.TrimSpace(p)
+			if !ParseProtocolVersionRe.MatchString(p) {
+				invalid(EnvTFProviderPlatforms, "contains protocol version %q, which must be formatted as \"MAJOR.MINOR\"", p)
+			}
+			c.tfProviderPlatforms = append(c.tfProviderPlatforms, p)
+		}
+	}
+
+	for _, n := range []struct {
+		env string
+		re  *regexp.Regexp
+	}{
+		{EnvTFOrganizationName, TFOrganizationNameRe},
+		{EnvTFNamespace, TFOrganizationNameRe},
+		{EnvTFProviderName, TFProviderNameRe},
+		{EnvTFModuleName, TFModuleNameRe},
+		{EnvTFModuleProvider, TFModuleProviderRe},
+	} {
+		if v := c.value(n.env); v != "" && !n.re.MatchString(v) {
+			invalid(n.env, "is not a valid name, it must match %q", n.re.String())
+		}
+	}
+
+	c.tfProviders = nil
+	if c.TFProviders != "" {
+		if c.TFProviderName != "" {
+			merr = multierror.Append(merr, fmt.Errorf("%q and %q must not both be set", inputName(EnvTFProviderName), inputName(EnvTFProviders)))
+		}
+		var perr error
+		if c.tfProviders, perr = parseProviders(c.TFProviders); perr != nil {
+			for _, e := range multierror.Append(nil, perr).Errors {
+				merr = multierror.Append(merr, fmt.Errorf("%s: %w", c.describeSetting(EnvTFProviders), e))
+			}
+		}
+	}
+
+	if c.TFRegistryName != "" && c.TFRegistryName != "private" && c.TFRegistryName != "public" {
+		invalid(EnvTFRegistryName, "must be either \"private\" or \"public\"")
+	}
+
+	c.tfTargets = nil
+	if c.TFTargets != "" {
+		var terr error
+		if c.tfTargets, terr = c.parseTargets(); terr != nil {
+			for _, e := range multierror.Append(nil, terr).Errors {
+				merr = multierror.Append(merr, fmt.Errorf("%s: %w", c.describeSetting(EnvTFTargets), e))
+			}
+		}
+	}
+
+	return merr
+}
+
+// runConfig inspects the configuration.  It is also run when the configuration is invalid, in which case the
+// problems are reported after the effective configuration.
+//
+// Usage:
+//
+//	config explain
+func runConfig(_ context.Context, done chan<- error, log zerolog.Logger, cfg *Config) {
+	var err error
+
+	defer func() {
+		done <- err
+	}()
+
+	if len(cfg.commandArgs) != 1 || cfg.commandArgs[0] != "explain" {
+		err = errors.New("usage: config explain")
+		return
+	}
+
+	cfg.explain(os.Stdout)
+
+	if cfg.configErr != nil {
+		problems := multierror.Append(nil, cfg.configErr).Errors
+		for _, e := range problems {
+			log.Error().Msg(e.Error())
+		}
+		err = fmt.Errorf("configuration has %d problem(s)", len(problems))
+		return
+	}
+
+	log.Info().Msg("Configuration is valid")
+}
+
+// explain writes the effective value of every setting along with its source
+func (c *Config) explain(w io.Writer) {
+	if c.configFile != "" {
+		_, _ = fmt.Fprintf(w, "Config file: %s\n\n", c.configFile)
+	} else {
+		_, _ = fmt.Fprintf(w, "Config file: none\n\n")
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "SETTING\tINPUT\tVALUE\tSOURCE")
+	for _, s := range c.settings() {
+		input := inputName(s.env)
+		if runnerEnvs[s.env] {
+			input = "-"
+		}
+
+		v, source := *s.value, c.sources[s.env]
+		if source == "" {
+			source = "unset"
+		} else if s.secret {
+			v = configRedacted
+		} else if s.env == EnvTFProxyURL {
+			if u, err := url.Parse(v); err == nil {
+				v = u.Redacted()
+			}
+		} else if s.document && len(c.tfTargets) > 0 {
+			v = "(see below)"
+		} else if s.document {
+			// an unparseable document may still hold a token
+			v = configRedacted
+		}
+
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", s.env, input, v, source)
+	}
+	_ = tw.Flush()
+
+	if len(c.tfTargets) == 0 {
+		return
+	}
+
+	// tokens are never shown, only where they were taken from
+	_, _ = fmt.Fprintf(w, "\nTargets:\n\n")
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "NAME\tADDRESS\tORGANIZATION\tREGISTRY\tNAMESPACE\tKEY-ID\tTOKEN")
+	for _, t := range c.tfTargets {
+		token := t.tokenSource
+		if token == "" {
+			token = "unset"
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", t.Name, t.Address, t.Organization, t.Registry, t.Namespace, t.KeyID, token)
+	}
+	_ = tw.Flush()
+}
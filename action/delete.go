@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/rs/zerolog"
+)
+
+// runDelete removes the provider version matching the release from the registry, or only the platforms provided as
+// arguments or with TF_DELETE_PLATFORMS, for every provider of the release in every target.  It is run automatically
+// in place of publish for "release: deleted" events.
+//
+// Usage:
+//
+//	delete [os_arch ...]
+func runDelete(ctx context.Context, done chan<- error, log zerolog.Logger, cfg *Config) {
+	var (
+		targets   []publishTarget
+		platforms [][2]string
+		err       error
+	)
+
+	defer func() {
+		done <- err
+	}()
+
+	if err = resolveRelease(ctx, log, cfg); err != nil {
+		return
+	}
+
+	if _, err = cfg.releaseVersion(); err != nil {
+		return
+	}
+
+	version := cfg.providerVersion()
+	log = log.With().Str("provider-version", version).Logger()
+
+	if platforms, err = deletePlatforms(cfg); err != nil {
+		return
+	}
+
+	if !cfg.tfConfirmDelete {
+		err = fmt.Errorf("refusing to delete from provider version %q, set %s to \"true\" to confirm", version, settingName(EnvTFConfirmDelete))
+		return
+	}
+
+	if targets, err = newPublishTargets(ctx, log, cfg, false); err != nil {
+		return
+	}
+
+	for _, t := range targets {
+		for _, p := range cfg.providers() {
+			plog := t.withTarget(log).With().Str("provider-name", p.Name).Logger()
+			if perr := deleteProvider(ctx, plog, t.regClient, t.cfg.forProvider(p), platforms); perr != nil {
+				err = multierror.Append(err, t.wrap(perr))
+			}
+		}
+	}
+}
+
+// deleteProvider removes the provider version, or only the given platforms, of a single provider
+func deleteProvider(ctx context.Context, log zerolog.Logger, regClient *RegistryClient, cfg *Config, platforms [][2]string) error {
+	version := cfg.providerVersion()
+
+	if len(platforms) == 0 {
+		log.Info().Msg("Deleting provider version...")
+
+		ctx, cancel := cfg.tfRequestContext(ctx)
+		defer cancel()
+		if err := regClient.DeleteProviderVersion(ctx, cfg, version); isNotFound(err) {
+			log.Info().Msg("Provider version does not exist, nothing to delete")
+		} else if err != nil {
+			return fmt.Errorf("error deleting provider %q version %q: %w", cfg.TFProviderName, version, err)
+		} else {
+			log.Info().Msg("Provider version deleted")
+		}
+		return nil
+	}
+
+	for _, p := range platforms {
+		plog := log.With().Str("os", p[0]).Str("arch", p[1]).Logger()
+		plog.Info().Msg("Deleting provider platform...")
+
+		ctx, cancel := cfg.tfRequestContext(ctx)
+		err := regClient.DeleteProviderVersionPlatform(ctx, cfg, version, p[0], p[1])
+		cancel()
+
+		if isNotFound(err) {
+			plog.Info().Msg("Provider platform does not exist, nothing to delete")
+		} else if err != nil {
+			return fmt.Errorf("error deleting provider %q platform %q: %w", cfg.TFProviderName, platformKey(p[0], p[1]), err)
+		} else {
+			plog.Info().Msg("Provider platform deleted")
+		}
+	}
+
+	return nil
+}
+
+// deletePlatforms returns the os and arch pairs to delete, an empty list meaning the entire version
+func deletePlatforms(cfg *Config) ([][2]string, error) {
+	names := cfg.commandArgs
+	if len(names) == 0 && cfg.TFDeletePlatforms != "" {
+		names = strings.Split(cfg.TFDeletePlatforms, ",")
+	}
+
+	out := make([][2]string, 0, len(names))
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		goos, goarch, ok := strings.Cut(name, "_")
+		if !ok || goos == "" || goarch == "" {
+			return nil, fmt.Errorf("platform %q must be formatted as \"os_arch\"", name)
+		}
+		out = append(out, [2]string{goos, goarch})
+	}
+
+	return out, nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	EnvGithubEventName = "GITHUB_EVENT_NAME"
+	EnvGithubEventPath = "GITHUB_EVENT_PATH"
+
+	GithubEventNameRelease = "release"
+
+	GithubReleaseActionDeleted = "deleted"
+)
+
+type (
+	GithubEventRelease struct {
+		ID         int64  `json:"id"`
+		TagName    string `json:"tag_name"`
+		Draft      bool   `json:"draft"`
+		Prerelease bool   `json:"prerelease"`
+	}
+
+	// GithubEvent contains the parts of the webhook payload that triggered the workflow this action cares about
+	GithubEvent struct {
+		Action  string              `json:"action"`
+		Release *GithubEventRelease `json:"release"`
+	}
+)
+
+// readGithubEvent parses the event payload file provided by the runner.  Outside a workflow run there is no payload,
+// which is not an error.
+func readGithubEvent(fname string) (*GithubEvent, error) {
+	if fname == "" {
+		return nil, nil
+	}
+
+	b, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return nil, fmt.Errorf("error reading event payload: %w", err)
+	}
+
+	ev := GithubEvent{}
+	if err = json.Unmarshal(b, &ev); err != nil {
+		return nil, fmt.Errorf("error unmarshalling event payload: %w", err)
+	}
+
+	return &ev, nil
+}
+
+// releaseRef determines which release to publish.  An explicitly configured tag or release id takes precedence over
+// the release that triggered the workflow, allowing manual runs to (re)publish any release.
+func releaseRef(cfg *Config) (GithubEventRelease, error) {
+	if cfg.ReleaseTag != "" || cfg.ReleaseID != "" {
+		rel := GithubEventRelease{TagName: cfg.ReleaseTag}
+		if cfg.ReleaseID != "" {
+			id, err := strconv.ParseInt(cfg.ReleaseID, 10, 64)
+			if err != nil || id <= 0 {
+				return GithubEventRelease{}, fmt.Errorf("value %q of %s is not a valid release id", cfg.ReleaseID, cfg.describeSetting(EnvReleaseID))
+			}
+			rel.ID = id
+		}
+		return rel, nil
+	}
+
+	if cfg.GithubEventName == GithubEventNameRelease && cfg.githubEvent != nil && cfg.githubEvent.Release != nil {
+		return *cfg.githubEvent.Release, nil
+	}
+
+	return GithubEventRelease{}, nil
+}
+
+// resolveRelease fills in the tag of a release only known by id, from which the version is derived
+func resolveRelease(ctx context.Context, log zerolog.Logger, cfg *Config) error {
+	if cfg.release.ID == 0 || cfg.release.TagName != "" {
+		return nil
+	}
+
+	if cfg.DistDir != "" {
+		return fmt.Errorf("%q must be set when reading assets from %q", EnvReleaseTag, EnvDistDir)
+	}
+
+	ghc, err := NewGithubClient(log, cfg)
+	if err != nil {
+		return fmt.Errorf("error constructing github.Client: %w", err)
+	}
+
+	ctx, cancel := cfg.ghRequestContext(ctx)
+	defer cancel()
+	rel, _, err := ghc.Repositories.GetRelease(ctx, cfg.GithubRepositoryOwner, cfg.githubRepository(), cfg.release.ID)
+	if err != nil {
+		return fmt.Errorf("error fetching release %d from github: %w", cfg.release.ID, err)
+	} else if rel.GetTagName() == "" {
+		return fmt.Errorf("release %d does not have a tag", cfg.release.ID)
+	}
+
+	cfg.release.TagName = rel.GetTagName()
+	cfg.release.Draft = rel.GetDraft()
+	cfg.release.Prerelease = rel.GetPrerelease()
+
+	log.Info().Msgf("Release %d resolved to tag %q", cfg.release.ID, cfg.release.TagName)
+
+	return nil
+}
+
+// checkRelease validates the release tag and warns about releases whose github flags are not reflected in the
+// registry.  The registry only knows about versions, a version without a pre-release suffix is installable by anyone
+// constraining on it.
+func checkRelease(log zerolog.Logger, cfg *Config) error {
+	if cfg.releaseTag() == "" {
+		return errors.New("unable to determine release tag")
+	}
+	v, err := cfg.releaseVersion()
+	if err != nil {
+		return err
+	}
+	if cfg.release.Draft {
+		log.Warn().Msg("Publishing a draft release")
+	}
+	if cfg.release.Prerelease && !v.IsPrerelease() {
+		log.Warn().Msgf("Release is marked as a pre-release, but version %q will be published as a regular release", v)
+	}
+	return nil
+}
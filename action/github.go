@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -19,16 +20,23 @@ import (
 const (
 	shasumSuffix           = "SHA256SUMS"
 	shasumSigSuffix        = "SHA256SUMS.sig"
+	manifestSuffix         = "_manifest.json"
 	zipSuffix              = ".zip"
 	sourceCodeArtifactName = "Source Code"
 )
 
 var (
-	ParseShasumLineRe = regexp.MustCompile("([^\\s]+)\\s+(.+_([0-9]+\\.[0-9]+\\.[0-9]+)_([^_]+)_([^.]+)\\.zip)$")
+	ParseProtocolVersionRe = regexp.MustCompile("^[0-9]+\\.[0-9]+$")
+
+	// the version group is validated by ParseSemVer, semantic versions never contain underscores
+	ParseShasumLineRe = regexp.MustCompile("([^\\s]+)\\s+(.+_([^_]+)_([^_]+)_([^._]+)\\.zip)$")
 )
 
-func NewGithubClient(cfg *Config) (*github.Client, error) {
+func NewGithubClient(log zerolog.Logger, cfg *Config) (*github.Client, error) {
 	ctx := context.Background()
+	if cfg.logHTTP {
+		ctx = context.WithValue(ctx, oauth2.HTTPClient, withHTTPLog(log, cfg, cleanhttp.DefaultPooledClient()))
+	}
 	ts := oauth2.StaticTokenSource(
 		&oauth2.Token{AccessToken: cfg.GithubToken},
 	)
@@ -58,6 +66,9 @@ type ShasumFile struct {
 	Filename string
 	Bytes    []byte
 	Entries  []ShasumFileEntry
+
+	// invalidLines contains an error per .zip line that could not be parsed into an entry
+	invalidLines []error
 }
 
 func (sf ShasumFile) entryByFilename(fname string) (ShasumFileEntry, bool) {
@@ -76,13 +87,37 @@ type ShasumSigFile struct {
 
 type ProviderArtifact struct {
 	ShasumFileEntry ShasumFileEntry
-	Asset           *github.ReleaseAsset
+	Asset           ReleaseAsset
+}
+
+// RegistryManifest is the terraform-registry-manifest.json file goreleaser attaches to the release as
+// {{ project }}_{{ version }}_manifest.json
+type RegistryManifest struct {
+	Version  int `json:"version"`
+	Metadata struct {
+		ProtocolVersions []string `json:"protocol_versions"`
+	} `json:"metadata"`
 }
 
 type GithubReleaseContext struct {
 	Shasum            ShasumFile
 	ShasumSig         ShasumSigFile
 	ProviderArtifacts []ProviderArtifact
+
+	// ProtocolVersions are the provider protocol versions the version is created with, see resolveProtocolVersions
+	ProtocolVersions []string
+
+	// Manifest is nil if the release does not contain a manifest
+	Manifest *RegistryManifest
+
+	// the names of all shasum and signature assets, and all binary assets whether or not they have a shasum entry
+	shasumAssets   []string
+	sigAssets      []string
+	manifestAssets []string
+	binaryAssets   []ReleaseAsset
+
+	// manifestErr is set if the manifest could not be parsed
+	manifestErr error
 }
 
 func shasumFileEntryFromLine(line []byte) (ShasumFileEntry, error) {
@@ -92,6 +127,10 @@ func shasumFileEntryFromLine(line []byte) (ShasumFileEntry, error) {
 		return ShasumFileEntry{}, fmt.Errorf("expected 6 groups in re match, saw %d: line=%q; matches=%v", l, string(line), matches)
 	}
 
+	if _, err := ParseSemVer(string(matches[3])); err != nil {
+		return ShasumFileEntry{}, fmt.Errorf("file %q has an invalid version: %w", string(matches[2]), err)
+	}
+
 	entry := ShasumFileEntry{
 		Shasum:   string(matches[1]),
 		Filename: string(matches[2]),
@@ -103,24 +142,26 @@ func shasumFileEntryFromLine(line []byte) (ShasumFileEntry, error) {
 	return entry, nil
 }
 
-func parseShasumFile(ctx context.Context, _ zerolog.Logger, ghc *github.Client, cfg *Config, asset *github.ReleaseAsset) (ShasumFile, error) {
+func parseShasumFile(ctx context.Context, _ zerolog.Logger, src ReleaseSource, cfg *Config, asset ReleaseAsset) (ShasumFile, error) {
 	ctx, cancel := cfg.ghRequestContext(ctx)
 	defer cancel()
-	rdr, _, err := ghc.Repositories.DownloadReleaseAsset(ctx, cfg.GithubRepositoryOwner, cfg.githubRepository(), *asset.ID, cleanhttp.DefaultClient())
-	if rdr != nil {
-		defer drainReader(rdr)
-	}
+	rdr, err := src.Open(ctx, asset)
 	if err != nil {
 		return ShasumFile{}, fmt.Errorf("error downloading shasum file asset: %w", err)
 	}
+	defer drainReader(rdr)
 
 	sumFile := ShasumFile{
-		Filename: *asset.Name,
-		Bytes:    make([]byte, 0),
+		Filename: asset.Name,
 		Entries:  make([]ShasumFileEntry, 0),
 	}
 
-	scanner := bufio.NewScanner(rdr)
+	// the bytes are kept exactly as published, they are what the signature was created over.
+	if sumFile.Bytes, err = ioutil.ReadAll(rdr); err != nil {
+		return ShasumFile{}, fmt.Errorf("error reading shasum file bytes: %w", err)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(sumFile.Bytes))
 	scanner.Split(bufio.ScanLines)
 
 	for scanner.Scan() {
@@ -129,30 +170,84 @@ func parseShasumFile(ctx context.Context, _ zerolog.Logger, ghc *github.Client,
 		if bytes.HasSuffix(line, []byte(".zip")) {
 			entry, err := shasumFileEntryFromLine(line)
 			if err != nil {
-				return ShasumFile{}, err
+				sumFile.invalidLines = append(sumFile.invalidLines, err)
+				continue
 			}
 			sumFile.Entries = append(sumFile.Entries, entry)
 		}
-
-		sumFile.Bytes = append(sumFile.Bytes, append(line, '\n')...)
 	}
 
 	return sumFile, nil
 }
 
-func fetchShasumSigFile(ctx context.Context, _ zerolog.Logger, ghc *github.Client, cfg *Config, asset *github.ReleaseAsset) (ShasumSigFile, error) {
+var errInvalidManifest = errors.New("invalid manifest")
+
+// fetchManifest reads the registry manifest.  Errors wrapping errInvalidManifest are problems with the contents.
+func fetchManifest(ctx context.Context, src ReleaseSource, cfg *Config, asset ReleaseAsset) (*RegistryManifest, error) {
 	ctx, cancel := cfg.ghRequestContext(ctx)
 	defer cancel()
-	rdr, _, err := ghc.Repositories.DownloadReleaseAsset(ctx, cfg.GithubRepositoryOwner, cfg.githubRepository(), *asset.ID, cleanhttp.DefaultClient())
-	if rdr != nil {
-		defer drainReader(rdr)
+	rdr, err := src.Open(ctx, asset)
+	if err != nil {
+		return nil, fmt.Errorf("error downloading manifest asset: %w", err)
 	}
+	defer drainReader(rdr)
+
+	b, err := ioutil.ReadAll(rdr)
+	if err != nil {
+		return nil, fmt.Errorf("error reading manifest bytes: %w", err)
+	}
+
+	m := RegistryManifest{}
+	if err = json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("%w %q: %v", errInvalidManifest, asset.Name, err)
+	} else if len(m.Metadata.ProtocolVersions) == 0 {
+		return nil, fmt.Errorf("%w %q: metadata.protocol_versions is empty", errInvalidManifest, asset.Name)
+	}
+
+	return &m, nil
+}
+
+// resolveProtocolVersions returns the protocol versions from the manifest, falling back to TF_PROVIDER_PLATFORMS and
+// then the default.  If both the manifest and TF_PROVIDER_PLATFORMS are present they must agree.
+func resolveProtocolVersions(cfg *Config, rc GithubReleaseContext) ([]string, error) {
+	var protocols []string
+
+	if rc.Manifest != nil {
+		protocols = rc.Manifest.Metadata.ProtocolVersions
+		if cfg.tfProviderPlatforms != nil && !sameStrings(protocols, cfg.tfProviderPlatforms) {
+			return nil, fmt.Errorf(
+				"%q value %q does not match the manifest protocol versions %q, remove the override or update the manifest",
+				EnvTFProviderPlatforms,
+				cfg.TFProviderPlatforms,
+				strings.Join(protocols, ","),
+			)
+		}
+	} else if cfg.tfProviderPlatforms != nil {
+		protocols = cfg.tfProviderPlatforms
+	} else {
+		protocols = strings.Split(TFProviderPlatformsDefault, ",")
+	}
+
+	for _, p := range protocols {
+		if !ParseProtocolVersionRe.MatchString(p) {
+			return nil, fmt.Errorf("protocol version %q must be formatted as \"MAJOR.MINOR\"", p)
+		}
+	}
+
+	return protocols, nil
+}
+
+func fetchShasumSigFile(ctx context.Context, _ zerolog.Logger, src ReleaseSource, cfg *Config, asset ReleaseAsset) (ShasumSigFile, error) {
+	ctx, cancel := cfg.ghRequestContext(ctx)
+	defer cancel()
+	rdr, err := src.Open(ctx, asset)
 	if err != nil {
 		return ShasumSigFile{}, err
 	}
+	defer drainReader(rdr)
 
 	sigFile := ShasumSigFile{
-		Filename: *asset.Name,
+		Filename: asset.Name,
 	}
 
 	if sigFile.Bytes, err = ioutil.ReadAll(rdr); err != nil {
@@ -162,61 +257,83 @@ func fetchShasumSigFile(ctx context.Context, _ zerolog.Logger, ghc *github.Clien
 	return sigFile, nil
 }
 
-func getReleaseContext(ctx context.Context, log zerolog.Logger, ghc *github.Client, cfg *Config) (GithubReleaseContext, error) {
-	rc := GithubReleaseContext{}
-
-	releaseMeta, _, err := ghc.Repositories.GetReleaseByTag(ctx, cfg.GithubRepositoryOwner, cfg.githubRepository(), cfg.GithubRefName)
+// getReleaseContext reads the release and validates it in full before it is used.  If the release has no signature
+// and a private key is configured, the shasum file is signed.
+func getReleaseContext(ctx context.Context, log zerolog.Logger, src ReleaseSource, cfg *Config, assets []ReleaseAsset) (GithubReleaseContext, error) {
+	rc, err := readReleaseContext(ctx, log, src, cfg, assets)
 	if err != nil {
-		err = fmt.Errorf("error fetching release metadata from github: %w", err)
 		return GithubReleaseContext{}, err
 	}
 
-	binaryArtifacts := make([]*github.ReleaseAsset, 0)
+	if err = lintReleaseContext(cfg, rc); err != nil {
+		return GithubReleaseContext{}, err
+	}
 
-	for _, asset := range releaseMeta.Assets {
-		if asset.Name == nil || asset.URL == nil || asset.ID == nil {
-			log.Debug().
-				Interface("id", asset.ID).
-				Interface("name", asset.Name).
-				Interface("url", asset.URL).
-				Msg("Skipping asset as at least one of name, url, and id are empty")
-			continue
+	if rc.ProtocolVersions, err = resolveProtocolVersions(cfg, rc); err != nil {
+		return GithubReleaseContext{}, err
+	}
+
+	log.Info().Strs("protocols", rc.ProtocolVersions).Msg("Protocol versions resolved")
+
+	if rc.ShasumSig.Filename == "" {
+		log.Info().Msg("No shasum sig file found in release, signing shasum file with configured private key")
+		if rc.ShasumSig, err = signShasumFile(cfg, rc.Shasum); err != nil {
+			return GithubReleaseContext{}, fmt.Errorf("error signing shasum file: %w", err)
 		}
-		log := log.With().Str("asset-name", *asset.Name).Logger()
-		if strings.HasSuffix(*asset.Name, shasumSuffix) {
+	}
+
+	return rc, nil
+}
+
+// readReleaseContext downloads and parses the shasum, signature and manifest files among the assets of a single
+// provider, see groupReleaseAssets, and pairs its binaries with their shasum entries.  Only errors reading the release
+// are returned, problems with its contents are left to lintReleaseContext.
+func readReleaseContext(ctx context.Context, log zerolog.Logger, src ReleaseSource, cfg *Config, assets []ReleaseAsset) (GithubReleaseContext, error) {
+	var err error
+
+	rc := GithubReleaseContext{}
+
+	for _, asset := range assets {
+		log := log.With().Str("asset-name", asset.Name).Logger()
+		if strings.HasSuffix(asset.Name, shasumSuffix) {
 			log.Info().Msg("Found shasum file")
-			if sumFile, err := parseShasumFile(ctx, log, ghc, cfg, asset); err != nil {
+			rc.shasumAssets = append(rc.shasumAssets, asset.Name)
+			if sumFile, err := parseShasumFile(ctx, log, src, cfg, asset); err != nil {
 				return GithubReleaseContext{}, err
 			} else {
 				rc.Shasum = sumFile
 			}
-		} else if strings.HasSuffix(*asset.Name, shasumSigSuffix) {
+		} else if strings.HasSuffix(asset.Name, shasumSigSuffix) {
 			log.Info().Msg("Found shasum sig file")
-			if sigFile, err := fetchShasumSigFile(ctx, log, ghc, cfg, asset); err != nil {
+			rc.sigAssets = append(rc.sigAssets, asset.Name)
+			if sigFile, err := fetchShasumSigFile(ctx, log, src, cfg, asset); err != nil {
 				return GithubReleaseContext{}, err
 			} else {
 				rc.ShasumSig = sigFile
 			}
-		} else if strings.HasPrefix(*asset.Name, sourceCodeArtifactName) {
+		} else if strings.HasSuffix(asset.Name, manifestSuffix) {
+			log.Info().Msg("Found manifest file")
+			rc.manifestAssets = append(rc.manifestAssets, asset.Name)
+			if rc.Manifest, err = fetchManifest(ctx, src, cfg, asset); err != nil && !errors.Is(err, errInvalidManifest) {
+				return GithubReleaseContext{}, err
+			}
+			rc.manifestErr = err
+		} else if strings.HasPrefix(asset.Name, sourceCodeArtifactName) {
 			// skip these
 			continue
-		} else if strings.HasSuffix(*asset.Name, zipSuffix) {
+		} else if strings.HasSuffix(asset.Name, zipSuffix) {
 			log.Info().Msg("Found binary asset")
-			binaryArtifacts = append(binaryArtifacts, asset)
+			rc.binaryAssets = append(rc.binaryAssets, asset)
 		}
 	}
 
-	if l := len(binaryArtifacts); l == 0 {
-		return GithubReleaseContext{}, errors.New("zero binary artifacts found in release")
-	} else {
-		log.Info().Msgf("Found %d binary artifacts", l)
-	}
+	log.Info().Msgf("Found %d binary artifacts", len(rc.binaryAssets))
 
 	rc.ProviderArtifacts = make([]ProviderArtifact, 0)
 
-	for _, ba := range binaryArtifacts {
-		log := log.With().Str("provider-artifact", *ba.Name).Logger()
-		if fe, ok := rc.Shasum.entryByFilename(*ba.Name); ok {
+	for _, ba := range rc.binaryAssets {
+		log := log.With().Str("provider-artifact", ba.Name).Logger()
+		if fe, ok := rc.Shasum.entryByFilename(ba.Name); ok {
 			log.Debug().Object("entry", fe).Msg("Found shasum entry")
 			rc.ProviderArtifacts = append(rc.ProviderArtifacts, ProviderArtifact{
 				ShasumFileEntry: fe,
@@ -225,10 +342,5 @@ func getReleaseContext(ctx context.Context, log zerolog.Logger, ghc *github.Clie
 		}
 	}
 
-	if len(rc.ProviderArtifacts) != len(binaryArtifacts) {
-		return GithubReleaseContext{},
-			fmt.Errorf("count mismatch: binaryArtifacts=%d; ProviderArtifacts=%d", len(rc.ProviderArtifacts), len(binaryArtifacts))
-	}
-
 	return rc, nil
 }
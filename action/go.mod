@@ -3,15 +3,18 @@ module github.com/dcarbone/tfcloud-provider-push-action/action
 go 1.23.0
 
 require (
+	github.com/ProtonMail/go-crypto v1.1.6
 	github.com/dcarbone/go-tfc v0.0.0-20220322162744-1ba33c6ca9c2
 	github.com/google/go-github/v47 v47.1.0
 	github.com/hashicorp/go-cleanhttp v0.5.2
 	github.com/hashicorp/go-multierror v1.1.1
 	github.com/rs/zerolog v1.28.0
 	golang.org/x/oauth2 v0.27.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
+	github.com/cloudflare/circl v1.3.7 // indirect
 	github.com/google/go-querystring v1.1.0 // indirect
 	github.com/hashicorp/errwrap v1.0.0 // indirect
 	github.com/mattn/go-colorable v0.1.12 // indirect
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/rs/zerolog"
+)
+
+// verifyReleaseSignature verifies the release's shasums signature using the configured public key
+func verifyReleaseSignature(ctx context.Context, log zerolog.Logger, regClient *RegistryClient, cfg *Config, rc GithubReleaseContext) error {
+	keyring, err := publicKeyring(ctx, log, regClient, cfg)
+	if err != nil {
+		return fmt.Errorf("error loading public key: %w", err)
+	}
+	return verifyShasumSignature(keyring, rc.Shasum, rc.ShasumSig)
+}
+
+// publicKeyring returns the keyring used to verify the release signature, read either from the configured file or
+// from the registry's GPG key endpoint for the configured key-id.
+func publicKeyring(ctx context.Context, log zerolog.Logger, regClient *RegistryClient, cfg *Config) (openpgp.EntityList, error) {
+	var armored []byte
+
+	if cfg.TFGPGPublicKeyFile != "" {
+		log.Debug().Msgf("Reading public key from %q", cfg.TFGPGPublicKeyFile)
+		b, err := ioutil.ReadFile(cfg.TFGPGPublicKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading public key file: %w", err)
+		}
+		armored = b
+	} else {
+		log.Debug().Msgf("Fetching public key %q from registry", cfg.TFGPGKeyID)
+		ctx, cancel := cfg.tfRequestContext(ctx)
+		defer cancel()
+		key, err := regClient.GetGPGKey(ctx, cfg.TFNamespace, cfg.TFGPGKeyID)
+		if err != nil {
+			return nil, fmt.Errorf("error fetching public key %q from registry: %w", cfg.TFGPGKeyID, err)
+		}
+		armored = []byte(key.Data.Attributes.ASCIIArmor)
+	}
+
+	keyring, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(armored))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing armored public key: %w", err)
+	}
+
+	if !keyringHasKeyID(keyring, cfg.TFGPGKeyID) {
+		return nil, fmt.Errorf("public key does not contain key-id %q", cfg.TFGPGKeyID)
+	}
+
+	return keyring, nil
+}
+
+func keyringHasKeyID(keyring openpgp.EntityList, keyID string) bool {
+	for _, e := range keyring {
+		if strings.EqualFold(e.PrimaryKey.KeyIdString(), keyID) {
+			return true
+		}
+		for _, sk := range e.Subkeys {
+			if strings.EqualFold(sk.PublicKey.KeyIdString(), keyID) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// verifyShasumSignature checks the detached signature against the exact bytes of the shasums file.  Terraform
+// requires a binary signature, so an armored one is reported as such even if it would otherwise be valid.
+func verifyShasumSignature(keyring openpgp.EntityList, sumFile ShasumFile, sigFile ShasumSigFile) error {
+	if len(sigFile.Bytes) == 0 {
+		return fmt.Errorf("signature file %q is empty", sigFile.Filename)
+	}
+
+	_, err := openpgp.CheckDetachedSignature(keyring, bytes.NewReader(sumFile.Bytes), bytes.NewReader(sigFile.Bytes), nil)
+	if err == nil {
+		return nil
+	}
+
+	if _, armorErr := openpgp.CheckArmoredDetachedSignature(keyring, bytes.NewReader(sumFile.Bytes), bytes.NewReader(sigFile.Bytes), nil); armorErr == nil {
+		return errors.New("signature is ASCII armored, Terraform requires a binary signature (gpg --detach-sign without --armor)")
+	}
+
+	return fmt.Errorf("signature %q does not verify %q: %w", sigFile.Filename, sumFile.Filename, err)
+}
+
+// signShasumFile produces a binary detached signature over the exact bytes of the shasum file using the configured
+// private key.  Neither the key nor the passphrase may ever be included in a log message or error.
+func signShasumFile(cfg *Config, sumFile ShasumFile) (ShasumSigFile, error) {
+	var (
+		armored    []byte
+		passphrase []byte
+		err        error
+	)
+
+	if cfg.TFGPGPrivateKey != "" {
+		armored = []byte(cfg.TFGPGPrivateKey)
+	} else if armored, err = ioutil.ReadFile(cfg.TFGPGPrivateKeyFile); err != nil {
+		return ShasumSigFile{}, fmt.Errorf("error reading private key file %q: %w", cfg.TFGPGPrivateKeyFile, err)
+	}
+
+	if cfg.TFGPGPassphrase != "" {
+		passphrase = []byte(cfg.TFGPGPassphrase)
+	} else if cfg.TFGPGPassphraseFile != "" {
+		if passphrase, err = ioutil.ReadFile(cfg.TFGPGPassphraseFile); err != nil {
+			return ShasumSigFile{}, fmt.Errorf("error reading passphrase file %q: %w", cfg.TFGPGPassphraseFile, err)
+		}
+		passphrase = bytes.TrimRight(passphrase, "\r\n")
+	}
+
+	keyring, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(armored))
+	if err != nil {
+		// the underlying error is deliberately not wrapped, it may quote parts of the key
+		return ShasumSigFile{}, errors.New("error parsing armored private key")
+	}
+
+	var signer *openpgp.Entity
+	for _, e := range keyring {
+		if e.PrivateKey != nil {
+			signer = e
+			break
+		}
+	}
+	if signer == nil {
+		return ShasumSigFile{}, errors.New("armored key does not contain a private key")
+	}
+
+	if err = decryptEntity(signer, passphrase); err != nil {
+		return ShasumSigFile{}, err
+	}
+
+	sigFile := ShasumSigFile{
+		Filename: fmt.Sprintf("%s.sig", sumFile.Filename),
+	}
+
+	buf := new(bytes.Buffer)
+	if err = openpgp.DetachSign(buf, signer, bytes.NewReader(sumFile.Bytes), nil); err != nil {
+		return ShasumSigFile{}, fmt.Errorf("error creating detached signature: %w", err)
+	}
+	sigFile.Bytes = buf.Bytes()
+
+	return sigFile, nil
+}
+
+// decryptEntity decrypts the primary and all sub private keys of an entity, as the signing key may be either
+func decryptEntity(e *openpgp.Entity, passphrase []byte) error {
+	if e.PrivateKey.Encrypted {
+		if len(passphrase) == 0 {
+			return errors.New("private key is encrypted but no passphrase was provided")
+		}
+		if err := e.PrivateKey.Decrypt(passphrase); err != nil {
+			return errors.New("error decrypting private key, is the passphrase correct?")
+		}
+	}
+	for _, sk := range e.Subkeys {
+		if sk.PrivateKey != nil && sk.PrivateKey.Encrypted {
+			if err := sk.PrivateKey.Decrypt(passphrase); err != nil {
+				return errors.New("error decrypting private sub key, is the passphrase correct?")
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/rs/zerolog"
+)
+
+const (
+	GPGKeyCommandList   = "list"
+	GPGKeyCommandAdd    = "add"
+	GPGKeyCommandDelete = "delete"
+	GPGKeyCommandRotate = "rotate"
+
+	// gpgKeyExpiryWarning is how far ahead of a key's expiry warnings are emitted
+	gpgKeyExpiryWarning = 30 * 24 * time.Hour
+)
+
+// runGPGKey manages the GPG keys registered for the configured namespace.
+//
+// Usage:
+//
+//	gpg-key list
+//	gpg-key add [public key file]
+//	gpg-key delete [key-id]
+//	gpg-key rotate [public key file]
+func runGPGKey(ctx context.Context, done chan<- error, log zerolog.Logger, cfg *Config) {
+	var (
+		regClient *RegistryClient
+		err       error
+	)
+
+	defer func() {
+		done <- err
+	}()
+
+	if len(cfg.commandArgs) == 0 {
+		err = fmt.Errorf("%s requires a sub command, one of: %s", CommandGPGKey, strings.Join([]string{GPGKeyCommandList, GPGKeyCommandAdd, GPGKeyCommandDelete, GPGKeyCommandRotate}, ", "))
+		return
+	}
+
+	if regClient, err = NewRegistryClient(ctx, log, cfg); err != nil {
+		err = fmt.Errorf("error constructing RegistryClient: %w", err)
+		return
+	}
+
+	sub, args := cfg.commandArgs[0], cfg.commandArgs[1:]
+	log = log.With().Str("namespace", cfg.TFNamespace).Logger()
+
+	switch sub {
+	case GPGKeyCommandList:
+		err = listGPGKeys(ctx, log, regClient, cfg)
+	case GPGKeyCommandAdd:
+		_, err = addGPGKey(ctx, log, regClient, cfg, args)
+	case GPGKeyCommandDelete:
+		err = deleteGPGKey(ctx, log, regClient, cfg, args)
+	case GPGKeyCommandRotate:
+		err = rotateGPGKey(ctx, log, regClient, cfg, args)
+	default:
+		err = fmt.Errorf("unknown %s sub command %q", CommandGPGKey, sub)
+	}
+}
+
+func listGPGKeys(ctx context.Context, log zerolog.Logger, regClient *RegistryClient, cfg *Config) error {
+	var (
+		keys []GPGKeyData
+		err  error
+	)
+
+	{
+		ctx, cancel := cfg.tfRequestContext(ctx)
+		defer cancel()
+		if keys, err = regClient.ListGPGKeys(ctx, cfg.TFNamespace); err != nil {
+			return fmt.Errorf("error listing gpg keys: %w", err)
+		}
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "KEY ID\tCREATED\tEXPIRES\tSTATUS")
+
+	for _, key := range keys {
+		expires, status := "never", "ok"
+		if expiry, ok, err := gpgKeyExpiry(key.Attributes.ASCIIArmor); err != nil {
+			expires, status = "unknown", "unparseable"
+			log.Warn().Err(err).Str("key-id", key.Attributes.KeyID).Msg("Unable to parse registered key")
+		} else if ok {
+			expires = expiry.Format(time.RFC3339)
+			status = gpgKeyStatus(log.With().Str("key-id", key.Attributes.KeyID).Logger(), expiry)
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", key.Attributes.KeyID, key.Attributes.CreatedAt, expires, status)
+	}
+
+	return tw.Flush()
+}
+
+func addGPGKey(ctx context.Context, log zerolog.Logger, regClient *RegistryClient, cfg *Config, args []string) (string, error) {
+	armored, err := readGPGPublicKey(cfg, args)
+	if err != nil {
+		return "", err
+	}
+
+	if expiry, ok, _ := gpgKeyExpiry(armored); ok {
+		gpgKeyStatus(log, expiry)
+	}
+
+	ctx, cancel := cfg.tfRequestContext(ctx)
+	defer cancel()
+	key, err := regClient.CreateGPGKey(ctx, NewCreateGPGKeyRequest(cfg.TFNamespace, armored))
+	if err != nil {
+		return "", fmt.Errorf("error adding gpg key: %w", err)
+	}
+
+	keyID := key.Data.Attributes.KeyID
+	log.Info().Str("key-id", keyID).Msg("GPG key added")
+
+	if err = setOutput("key-id", keyID); err != nil {
+		return "", err
+	}
+
+	return keyID, nil
+}
+
+func deleteGPGKey(ctx context.Context, log zerolog.Logger, regClient *RegistryClient, cfg *Config, args []string) error {
+	keyID := cfg.TFGPGKeyID
+	if len(args) > 0 {
+		keyID = args[0]
+	}
+	if keyID == "" {
+		return fmt.Errorf("key-id must be provided as an argument or with %q", EnvTFGPGKeyID)
+	}
+
+	ctx, cancel := cfg.tfRequestContext(ctx)
+	defer cancel()
+	if err := regClient.DeleteGPGKey(ctx, cfg.TFNamespace, keyID); err != nil {
+		return fmt.Errorf("error deleting gpg key %q: %w", keyID, err)
+	}
+
+	log.Info().Str("key-id", keyID).Msg("GPG key deleted")
+
+	return nil
+}
+
+// rotateGPGKey registers a new key and publishes its key-id as the "key-id" step output.  Previous keys are left in
+// place, provider versions signed with them still reference them.
+func rotateGPGKey(ctx context.Context, log zerolog.Logger, regClient *RegistryClient, cfg *Config, args []string) error {
+	armored, err := readGPGPublicKey(cfg, args)
+	if err != nil {
+		return err
+	}
+
+	keyring, err := openpgp.ReadArmoredKeyRing(strings.NewReader(armored))
+	if err != nil {
+		return fmt.Errorf("error parsing armored public key: %w", err)
+	}
+
+	var existing []GPGKeyData
+	{
+		ctx, cancel := cfg.tfRequestContext(ctx)
+		defer cancel()
+		if existing, err = regClient.ListGPGKeys(ctx, cfg.TFNamespace); err != nil {
+			return fmt.Errorf("error listing gpg keys: %w", err)
+		}
+	}
+
+	// re-running a rotation must not fail because the key was registered by the previous attempt
+	for _, key := range existing {
+		if keyringHasKeyID(keyring, key.Attributes.KeyID) {
+			log.Info().Str("key-id", key.Attributes.KeyID).Msg("GPG key is already registered")
+			return setOutput("key-id", key.Attributes.KeyID)
+		}
+	}
+
+	keyID, err := addGPGKey(ctx, log, regClient, cfg, args)
+	if err != nil {
+		return err
+	}
+
+	if cfg.TFGPGKeyID != "" && !strings.EqualFold(cfg.TFGPGKeyID, keyID) {
+		log.Info().Msgf("Previous key %q has been kept, update %q to %q for future releases", cfg.TFGPGKeyID, EnvTFGPGKeyID, keyID)
+	}
+
+	return nil
+}
+
+// readGPGPublicKey reads the armored public key from the file provided as argument, or the configured public key file
+func readGPGPublicKey(cfg *Config, args []string) (string, error) {
+	fname := cfg.TFGPGPublicKeyFile
+	if len(args) > 0 {
+		fname = args[0]
+	}
+	if fname == "" {
+		return "", fmt.Errorf("public key file must be provided as an argument or with %q", EnvTFGPGPublicKeyFile)
+	}
+
+	b, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return "", fmt.Errorf("error reading public key file: %w", err)
+	}
+
+	if bytes.Contains(b, []byte("PRIVATE KEY")) {
+		return "", errors.New("refusing to register a private key, provide the armored public key instead")
+	}
+
+	return string(b), nil
+}
+
+// gpgKeyExpiry returns the earliest expiry among the signing keys of an armored public key, if any expires.  That is
+// the primary key, along with each signing subkey, as the key can no longer sign once either has expired.
+func gpgKeyExpiry(armored string) (time.Time, bool, error) {
+	keyring, err := openpgp.ReadArmoredKeyRing(strings.NewReader(armored))
+	if err != nil {
+		return time.Time{}, false, err
+	} else if len(keyring) == 0 {
+		return time.Time{}, false, errors.New("no keys found")
+	}
+
+	var (
+		e      = keyring[0]
+		now    = time.Now()
+		expiry time.Time
+	)
+
+	// the primary key is valid for as long as its latest identity self-signature allows
+	for _, ident := range e.Identities {
+		if t, ok := keyLifetimeExpiry(e.PrimaryKey, ident.SelfSignature); ok && t.After(expiry) {
+			expiry = t
+		}
+	}
+
+	for _, sk := range e.Subkeys {
+		if sk.Sig == nil || !sk.Sig.FlagsValid || !sk.Sig.FlagSign || sk.Revoked(now) {
+			continue
+		}
+		if t, ok := keyLifetimeExpiry(sk.PublicKey, sk.Sig); ok && (expiry.IsZero() || t.Before(expiry)) {
+			expiry = t
+		}
+	}
+
+	return expiry, !expiry.IsZero(), nil
+}
+
+// keyLifetimeExpiry returns the expiry of pk set by the key lifetime of sig, if it has one.  A lifetime of zero never
+// expires.
+func keyLifetimeExpiry(pk *packet.PublicKey, sig *packet.Signature) (time.Time, bool) {
+	if sig == nil || sig.KeyLifetimeSecs == nil || *sig.KeyLifetimeSecs == 0 {
+		return time.Time{}, false
+	}
+	return pk.CreationTime.Add(time.Duration(*sig.KeyLifetimeSecs) * time.Second), true
+}
+
+// gpgKeyStatus logs a warning if the key has expired or is about to, returning a short status description
+func gpgKeyStatus(log zerolog.Logger, expiry time.Time) string {
+	now := time.Now()
+	if expiry.Before(now) {
+		log.Warn().Time("expired", expiry).Msg("GPG key has expired")
+		return "expired"
+	} else if expiry.Before(now.Add(gpgKeyExpiryWarning)) {
+		log.Warn().Time("expires", expiry).Msg("GPG key expires soon")
+		return "expires soon"
+	}
+	return "ok"
+}
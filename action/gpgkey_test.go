@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+func TestGPGKeyExpiry(t *testing.T) {
+	const day = 24 * 60 * 60
+
+	created := time.Now().Truncate(time.Second)
+
+	// newKey generates an ed25519 key, the primary key expiring after primary seconds, with a signing and an encryption
+	// subkey expiring after sign and encrypt seconds.  Zero never expires.
+	newKey := func(t *testing.T, primary, sign, encrypt uint32) string {
+		t.Helper()
+
+		config := func(lifetime uint32) *packet.Config {
+			return &packet.Config{
+				Algorithm:       packet.PubKeyAlgoEdDSA,
+				KeyLifetimeSecs: lifetime,
+				Time:            func() time.Time { return created },
+			}
+		}
+
+		e, err := openpgp.NewEntity("Test", "", "test@example.com", config(primary))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// NewEntity adds an encryption subkey with the lifetime of the primary key, replace it
+		e.Subkeys = nil
+		if err = e.AddEncryptionSubkey(config(encrypt)); err != nil {
+			t.Fatal(err)
+		}
+		if err = e.AddSigningSubkey(config(sign)); err != nil {
+			t.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		w, err := armor.Encode(&buf, openpgp.PublicKeyType, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = e.Serialize(w); err != nil {
+			t.Fatal(err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.String()
+	}
+
+	tests := []struct {
+		name    string
+		primary uint32
+		sign    uint32
+		encrypt uint32
+		expiry  uint32
+	}{
+		{name: "never-expires"},
+		{name: "primary-expires", primary: 90 * day, expiry: 90 * day},
+		{name: "signing-subkey-expires", sign: 10 * day, expiry: 10 * day},
+		{name: "signing-subkey-expires-first", primary: 90 * day, sign: 10 * day, expiry: 10 * day},
+		{name: "primary-expires-first", primary: 10 * day, sign: 90 * day, expiry: 10 * day},
+		{name: "encryption-subkey-ignored", encrypt: 10 * day},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expiry, ok, err := gpgKeyExpiry(newKey(t, tt.primary, tt.sign, tt.encrypt))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.expiry == 0 {
+				if ok {
+					t.Fatalf("expected no expiry, got %s", expiry)
+				}
+				return
+			}
+			if expected := created.Add(time.Duration(tt.expiry) * time.Second); !ok || !expiry.Equal(expected) {
+				t.Fatalf("gpgKeyExpiry() = %s (%t), expected %s", expiry, ok, expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-cleanhttp"
+	"github.com/rs/zerolog"
+)
+
+// newTFHTTPClient constructs an http client for talking to Terraform Cloud / Enterprise, applying any configured CA
+// bundle, client certificate and proxy.  API calls use a pooled client, uploads use a non-pooled one.
+func newTFHTTPClient(log zerolog.Logger, cfg *Config, pooled bool) (*http.Client, error) {
+	var (
+		hc  *http.Client
+		tr  *http.Transport
+		err error
+	)
+
+	if pooled {
+		hc = cleanhttp.DefaultPooledClient()
+	} else {
+		hc = cleanhttp.DefaultClient()
+	}
+
+	tr = hc.Transport.(*http.Transport)
+
+	if tr.TLSClientConfig, err = newTFTLSConfig(cfg); err != nil {
+		return nil, err
+	}
+
+	if cfg.TFProxyURL != "" {
+		proxyURL, err := url.Parse(cfg.TFProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing proxy url: %w", err)
+		}
+		tr.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	return withHTTPLog(log, cfg, hc), nil
+}
+
+// withHTTPLog wraps the transport of hc so every request is logged at debug level, when enabled with LOG_HTTP
+func withHTTPLog(log zerolog.Logger, cfg *Config, hc *http.Client) *http.Client {
+	if cfg.logHTTP {
+		hc.Transport = &httpLogTransport{next: hc.Transport, log: log}
+	}
+	return hc
+}
+
+// httpLogTransport logs the method, url, status and duration of each request.  Query strings are left out, as they
+// may hold the signature of a pre-signed upload url.
+type httpLogTransport struct {
+	next http.RoundTripper
+	log  zerolog.Logger
+}
+
+func (t *httpLogTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+
+	log := groupedLog(req.Context(), t.log)
+	ev := log.Debug().
+		Str("method", req.Method).
+		Str("url", fmt.Sprintf("%s://%s%s", req.URL.Scheme, req.URL.Host, req.URL.EscapedPath())).
+		Dur("duration", time.Since(start))
+	if req.Header.Get("Range") != "" {
+		ev.Str("range", req.Header.Get("Range"))
+	}
+
+	if err != nil {
+		ev.Err(err).Msg("HTTP request failed")
+		return resp, err
+	}
+
+	ev.Int("status", resp.StatusCode).Int64("content-length", resp.ContentLength)
+	for _, h := range []string{"X-Request-Id", "X-Ratelimit-Remaining", "Retry-After"} {
+		if v := resp.Header.Get(h); v != "" {
+			ev.Str(strings.ToLower(h), v)
+		}
+	}
+	ev.Msg("HTTP request")
+
+	return resp, err
+}
+
+func newTFTLSConfig(cfg *Config) (*tls.Config, error) {
+	tlsConf := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if cfg.TFCACertFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		b, err := ioutil.ReadFile(cfg.TFCACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading CA bundle %q: %w", cfg.TFCACertFile, err)
+		}
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no PEM encoded certificates found in CA bundle %q", cfg.TFCACertFile)
+		}
+		tlsConf.RootCAs = pool
+	}
+
+	if cfg.TFClientCertFile != "" || cfg.TFClientKeyFile != "" {
+		if cfg.TFClientCertFile == "" || cfg.TFClientKeyFile == "" {
+			return nil, fmt.Errorf("both %q and %q must be provided to use a client certificate", EnvTFClientCertFile, EnvTFClientKeyFile)
+		}
+		cert, err := tls.LoadX509KeyPair(cfg.TFClientCertFile, cfg.TFClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading client certificate: %w", err)
+		}
+		tlsConf.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConf, nil
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/rs/zerolog"
+)
+
+// runLint validates the release without making any registry calls, reporting every problem found.  It is intended
+// to be run against a snapshot build, e.g. on pull requests.
+func runLint(ctx context.Context, done chan<- error, log zerolog.Logger, cfg *Config) {
+	var (
+		src ReleaseSource
+		err error
+	)
+
+	defer func() {
+		done <- err
+	}()
+
+	if err = resolveRelease(ctx, log, cfg); err != nil {
+		return
+	}
+
+	log = log.With().Str("provider-version", cfg.providerVersion()).Logger()
+
+	if src, err = NewReleaseSource(log, cfg); err != nil {
+		err = fmt.Errorf("error constructing release source: %w", err)
+		return
+	}
+
+	var assets []ReleaseAsset
+	if assets, err = listReleaseAssets(ctx, log, src, cfg); err != nil {
+		return
+	}
+
+	groups, gerr := groupReleaseAssets(cfg, assets)
+
+	findings := new(multierror.Error)
+	findings = multierror.Append(findings, gerr)
+
+	for _, p := range cfg.providers() {
+		var (
+			pcfg = cfg.forProvider(p)
+			plog = log.With().Str("provider-name", p.Name).Logger()
+			rc   GithubReleaseContext
+		)
+
+		if rc, err = readReleaseContext(ctx, plog, src, pcfg, groups[p.Name]); err != nil {
+			err = fmt.Errorf("error reading release: %w", err)
+			return
+		}
+
+		findings = multierror.Append(findings, providerFindings(cfg, p, lintReleaseContext(pcfg, rc))...)
+
+		// the signature can only be checked up front when the public key is available locally
+		if cfg.TFGPGPublicKeyFile != "" && cfg.TFGPGKeyID != "" && rc.ShasumSig.Filename != "" && rc.Shasum.Filename != "" {
+			if keyring, kerr := publicKeyring(ctx, plog, nil, pcfg); kerr != nil {
+				findings = multierror.Append(findings, fmt.Errorf("error loading public key: %w", kerr))
+			} else if verr := verifyShasumSignature(keyring, rc.Shasum, rc.ShasumSig); verr != nil {
+				findings = multierror.Append(findings, providerFindings(cfg, p, verr)...)
+			}
+		}
+	}
+
+	if err = reportFindings(log, findings.ErrorOrNil()); err != nil {
+		return
+	}
+
+	log.Info().Msg("No problems found")
+}
+
+// reportFindings logs each problem found by lintReleaseContext individually, summarizing them in the returned error.
+// Any other error is returned as-is.
+func reportFindings(log zerolog.Logger, err error) error {
+	var me *multierror.Error
+	if !errors.As(err, &me) || me.Len() == 0 {
+		return err
+	}
+	for _, e := range me.Errors {
+		log.Error().Msg(e.Error())
+	}
+	return fmt.Errorf("release has %d problem(s)", me.Len())
+}
+
+// lintReleaseContext checks the contents of the release, returning a *multierror.Error with every problem found
+func lintReleaseContext(cfg *Config, rc GithubReleaseContext) error {
+	var result *multierror.Error
+
+	add := func(format string, args ...interface{}) {
+		result = multierror.Append(result, fmt.Errorf(format, args...))
+	}
+
+	version, verr := cfg.releaseVersion()
+	if verr != nil {
+		result = multierror.Append(result, verr)
+	}
+
+	switch len(rc.shasumAssets) {
+	case 0:
+		add("no %s file found in release", shasumSuffix)
+	case 1:
+	default:
+		add("found %d %s files in release, expected 1: %s", len(rc.shasumAssets), shasumSuffix, strings.Join(rc.shasumAssets, ", "))
+	}
+
+	switch len(rc.sigAssets) {
+	case 0:
+		if !cfg.canSign() {
+			add("no %s file found in release and no private key configured to create one", shasumSigSuffix)
+		}
+	case 1:
+		if rc.Shasum.Filename != "" && rc.ShasumSig.Filename != fmt.Sprintf("%s.sig", rc.Shasum.Filename) {
+			add("signature file %q does not belong to shasum file %q", rc.ShasumSig.Filename, rc.Shasum.Filename)
+		}
+	default:
+		add("found %d %s files in release, expected 1: %s", len(rc.sigAssets), shasumSigSuffix, strings.Join(rc.sigAssets, ", "))
+	}
+
+	if len(rc.manifestAssets) > 1 {
+		add("found %d %s files in release, expected at most 1: %s", len(rc.manifestAssets), manifestSuffix, strings.Join(rc.manifestAssets, ", "))
+	} else if rc.manifestErr != nil {
+		result = multierror.Append(result, rc.manifestErr)
+	}
+	if _, err := resolveProtocolVersions(cfg, rc); err != nil {
+		result = multierror.Append(result, err)
+	}
+
+	for _, err := range rc.Shasum.invalidLines {
+		result = multierror.Append(result, err)
+	}
+
+	if len(rc.binaryAssets) == 0 {
+		add("zero binary artifacts found in release")
+	}
+
+	prefix := fmt.Sprintf("terraform-provider-%s_", cfg.TFProviderName)
+	assetNames := make(map[string]struct{}, len(rc.binaryAssets))
+
+	for _, ba := range rc.binaryAssets {
+		assetNames[ba.Name] = struct{}{}
+		if !strings.HasPrefix(ba.Name, prefix) {
+			add("binary %q does not belong to provider %q, expected the name to start with %q", ba.Name, cfg.TFProviderName, prefix)
+		}
+		if _, ok := rc.Shasum.entryByFilename(ba.Name); !ok && rc.Shasum.Filename != "" {
+			add("binary %q has no entry in %q", ba.Name, rc.Shasum.Filename)
+		}
+	}
+
+	platforms := make(map[string]string, len(rc.Shasum.Entries))
+
+	for _, fe := range rc.Shasum.Entries {
+		if _, ok := assetNames[fe.Filename]; !ok {
+			add("%q entry %q has no matching release asset", rc.Shasum.Filename, fe.Filename)
+		}
+		if verr == nil && fe.Version != version.String() {
+			add("file %q has version %q, but the release tag %q is version %q", fe.Filename, fe.Version, cfg.releaseTag(), version)
+		}
+		key := platformKey(fe.OS, fe.Arch)
+		if prev, ok := platforms[key]; ok {
+			add("files %q and %q are both built for %q", prev, fe.Filename, key)
+		} else {
+			platforms[key] = fe.Filename
+		}
+	}
+
+	return result.ErrorOrNil()
+}
@@ -1,24 +1,146 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
 	"os"
+	"strings"
+	"sync"
 
 	"github.com/rs/zerolog"
 )
 
+const (
+	LogFormatAuto    = "auto"
+	LogFormatConsole = "console"
+	LogFormatJSON    = "json"
+	LogFormatGithub  = "github"
+)
+
+// githubLog is the writer of the logger in the github log format, used to group log output.  nil in any other format.
+var githubLog *githubWriter
+
 func newLogger(cfg *Config) zerolog.Logger {
-	return zerolog.New(
-		zerolog.NewConsoleWriter(
+	var w io.Writer
+
+	switch cfg.logFormat {
+	case LogFormatJSON:
+		w = os.Stdout
+	case LogFormatGithub:
+		githubLog = newGithubWriter(os.Stdout)
+		w = githubLog
+	default:
+		w = zerolog.NewConsoleWriter(
 			func(w *zerolog.ConsoleWriter) {
 				w.Out = os.Stdout
 			},
-		),
-	).
+		)
+	}
+
+	return zerolog.New(newRedactingWriter(w)).
+		Level(cfg.logLevel).
 		With().
 		Timestamp().
 		Str("github-repo", cfg.GithubRepository).
 		Str("ref-name", cfg.GithubRefName).
-		Str("provider-name", cfg.TFProviderName).
-		Str("provider-version", cfg.providerVersion()).
 		Logger()
 }
+
+type logGroupKey struct{}
+
+// logGroup returns a logger whose output is held back until done is called, then written as a single collapsible
+// group, so the logs of concurrent uploads are not interleaved.  The group is carried by the returned context, see
+// groupedLog.  Outside the github log format ctx and log are returned as is.
+func logGroup(ctx context.Context, log zerolog.Logger, title string) (context.Context, zerolog.Logger, func()) {
+	if githubLog == nil {
+		return ctx, log, func() {}
+	}
+	g := githubLog.group()
+	return context.WithValue(ctx, logGroupKey{}, g), log.Output(newRedactingWriter(g)), func() { githubLog.flush(title, g) }
+}
+
+// groupedLog returns log writing to the log group of ctx, if any.  It allows loggers shared by every upload, such as
+// that of a registry client, to log into the group of the upload making a request.
+func groupedLog(ctx context.Context, log zerolog.Logger) zerolog.Logger {
+	if g, ok := ctx.Value(logGroupKey{}).(*githubWriter); ok {
+		return log.Output(newRedactingWriter(g))
+	}
+	return log
+}
+
+// githubWriter formats log events for the workflow log.  Warnings and errors are written as workflow commands, so
+// they are also shown as annotations on the run.  Timestamps are left out as the runner adds its own.
+type githubWriter struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+func newGithubWriter(out io.Writer) *githubWriter {
+	w := githubWriter{out: out}
+	return &w
+}
+
+func (w *githubWriter) Write(p []byte) (int, error) {
+	return w.WriteLevel(zerolog.NoLevel, p)
+}
+
+func (w *githubWriter) WriteLevel(level zerolog.Level, p []byte) (int, error) {
+	var (
+		buf bytes.Buffer
+		cmd string
+	)
+
+	switch level {
+	case zerolog.WarnLevel:
+		cmd = "warning"
+	case zerolog.ErrorLevel, zerolog.FatalLevel, zerolog.PanicLevel:
+		cmd = "error"
+	}
+
+	cw := zerolog.ConsoleWriter{
+		Out:          &buf,
+		NoColor:      true,
+		PartsExclude: []string{zerolog.TimestampFieldName},
+	}
+	if cmd != "" {
+		cw.PartsExclude = append(cw.PartsExclude, zerolog.LevelFieldName)
+	}
+	if _, err := cw.Write(p); err != nil {
+		return 0, err
+	}
+
+	line := strings.TrimRight(buf.String(), "\n")
+	if cmd != "" {
+		line = fmt.Sprintf("::%s::%s", cmd, escapeCommandData(line))
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, err := fmt.Fprintln(w.out, line); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// group returns a writer buffering its output until flushed
+func (w *githubWriter) group() *githubWriter {
+	return newGithubWriter(new(bytes.Buffer))
+}
+
+// flush writes everything buffered by g as a single group, unless nothing was logged
+func (w *githubWriter) flush(title string, g *githubWriter) {
+	g.mu.Lock()
+	buf := g.out.(*bytes.Buffer)
+	defer g.mu.Unlock()
+
+	if buf.Len() == 0 {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, _ = fmt.Fprintf(w.out, "::group::%s\n%s::endgroup::\n", escapeCommandData(title), buf.Bytes())
+}
@@ -9,12 +9,9 @@ import (
 	"os"
 	"os/signal"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/dcarbone/go-tfc"
-	"github.com/google/go-github/v47/github"
-	"github.com/hashicorp/go-cleanhttp"
 	"github.com/hashicorp/go-multierror"
 	"github.com/rs/zerolog"
 )
@@ -23,10 +20,23 @@ const (
 	GithubRequestTTLDefault  = "5s"
 	GithubDownloadTTLDefault = "5m"
 
-	TFRegistryNameDefault      = "private"
-	TFProviderPlatformsDefault = "6.0"
-	TFRequestTTLDefault        = "5s"
-	TFUploadTTLDefault         = "5m"
+	LogLevelDefault  = "info"
+	LogFormatDefault = LogFormatAuto
+	LogHTTPDefault   = "false"
+
+	TFRegistryNameDefault        = "private"
+	TFProviderPlatformsDefault   = "6.0"
+	TFRequestTTLDefault          = "5s"
+	TFUploadTTLDefault           = "5m"
+	TFMaxRetriesDefault          = "4"
+	TFRetryWaitMinDefault        = "1s"
+	TFRetryWaitMaxDefault        = "30s"
+	TFRateLimitDefault           = "20"
+	TFDownloadConcurrencyDefault = "4"
+	TFUploadConcurrencyDefault   = "4"
+	TFFailureModeDefault         = FailureModeBestEffort
+	TFCreateProviderDefault      = "false"
+	TFConfirmDeleteDefault       = "false"
 
 	EnvGithubToken           = "GITHUB_TOKEN"
 	EnvGithubRefName         = "GITHUB_REF_NAME"
@@ -35,47 +45,182 @@ const (
 	EnvGithubRequestTTL      = "GITHUB_REQUEST_TTL"
 	EnvGithubDownloadTTL     = "GITHUB_DOWNLOAD_TTL"
 
-	EnvTFAddress           = "TF_ADDRESS"
-	EnvTFToken             = "TF_TOKEN"
-	EnvTFGPGKeyID          = "TF_GPG_KEY_ID"
-	EnvTFRegistryName      = "TF_REGISTRY_NAME"
-	EnvTFOrganizationName  = "TF_ORGANIZATION_NAME"
-	EnvTFNamespace         = "TF_NAMESPACE"
-	EnvTFProviderName      = "TF_PROVIDER_NAME"
-	EnvTFProviderPlatforms = "TF_PROVIDER_PLATFORMS"
-	EnvTFRequestTTL        = "TF_REQUEST_TTL"
-	EnvTFUploadTTL         = "TF_UPLOAD_TTL"
+	EnvDistDir    = "DIST_DIR"
+	EnvReleaseTag = "RELEASE_TAG"
+	EnvReleaseID  = "RELEASE_ID"
+
+	EnvLogLevel  = "LOG_LEVEL"
+	EnvLogFormat = "LOG_FORMAT"
+	EnvLogHTTP   = "LOG_HTTP"
+
+	EnvTFAddress             = "TF_ADDRESS"
+	EnvTFToken               = "TF_TOKEN"
+	EnvTFGPGKeyID            = "TF_GPG_KEY_ID"
+	EnvTFRegistryName        = "TF_REGISTRY_NAME"
+	EnvTFOrganizationName    = "TF_ORGANIZATION_NAME"
+	EnvTFNamespace           = "TF_NAMESPACE"
+	EnvTFProviderName        = "TF_PROVIDER_NAME"
+	EnvTFProviders           = "TF_PROVIDERS"
+	EnvTFProviderPlatforms   = "TF_PROVIDER_PLATFORMS"
+	EnvTFRequestTTL          = "TF_REQUEST_TTL"
+	EnvTFUploadTTL           = "TF_UPLOAD_TTL"
+	EnvTFMaxRetries          = "TF_MAX_RETRIES"
+	EnvTFRetryWaitMin        = "TF_RETRY_WAIT_MIN"
+	EnvTFRetryWaitMax        = "TF_RETRY_WAIT_MAX"
+	EnvTFRateLimit           = "TF_RATE_LIMIT"
+	EnvTFDownloadConcurrency = "TF_DOWNLOAD_CONCURRENCY"
+	EnvTFUploadConcurrency   = "TF_UPLOAD_CONCURRENCY"
+	EnvTFFailureMode         = "TF_FAILURE_MODE"
+	EnvTFSpoolDir            = "TF_SPOOL_DIR"
+	EnvTFCACertFile          = "TF_CA_CERT_FILE"
+	EnvTFClientCertFile      = "TF_CLIENT_CERT_FILE"
+	EnvTFClientKeyFile       = "TF_CLIENT_KEY_FILE"
+	EnvTFProxyURL            = "TF_PROXY_URL"
+	EnvTFGPGPublicKeyFile    = "TF_GPG_PUBLIC_KEY_FILE"
+	EnvTFGPGPrivateKey       = "TF_GPG_PRIVATE_KEY"
+	EnvTFGPGPrivateKeyFile   = "TF_GPG_PRIVATE_KEY_FILE"
+	EnvTFGPGPassphrase       = "TF_GPG_PASSPHRASE"
+	EnvTFGPGPassphraseFile   = "TF_GPG_PASSPHRASE_FILE"
+	EnvTFCreateProvider      = "TF_CREATE_PROVIDER"
+	EnvTFModuleName          = "TF_MODULE_NAME"
+	EnvTFModuleProvider      = "TF_MODULE_PROVIDER"
+	EnvTFConfirmDelete       = "TF_CONFIRM_DELETE"
+	EnvTFDeletePlatforms     = "TF_DELETE_PLATFORMS"
 )
 
 type Config struct {
+	ConfigFile string
+
 	GithubToken           string
 	GithubRefName         string
 	GithubRepository      string
 	GithubRepositoryOwner string
 	GithubRequestTTL      string
 	GithubDownloadTTL     string
-
-	TFAddress           string
-	TFToken             string
-	TFGPGKeyID          string
-	TFRegistryName      string
-	TFOrganizationName  string
-	TFNamespace         string
-	TFProviderName      string
-	TFProviderPlatforms string
-	TFRequestTTL        string
-	TFUploadTTL         string
+	GithubEventName       string
+	GithubEventPath       string
+
+	DistDir    string
+	ReleaseTag string
+	ReleaseID  string
+
+	LogLevel  string
+	LogFormat string
+	LogHTTP   string
+
+	TFAddress             string
+	TFToken               string
+	TFGPGKeyID            string
+	TFRegistryName        string
+	TFOrganizationName    string
+	TFNamespace           string
+	TFProviderName        string
+	TFProviders           string
+	TFTargets             string
+	TFProviderPlatforms   string
+	TFRequestTTL          string
+	TFUploadTTL           string
+	TFMaxRetries          string
+	TFRetryWaitMin        string
+	TFRetryWaitMax        string
+	TFRateLimit           string
+	TFDownloadConcurrency string
+	TFUploadConcurrency   string
+	TFFailureMode         string
+	TFSpoolDir            string
+	TFCACertFile          string
+	TFClientCertFile      string
+	TFClientKeyFile       string
+	TFProxyURL            string
+	TFGPGPublicKeyFile    string
+	TFGPGPrivateKey       string
+	TFGPGPrivateKeyFile   string
+	TFGPGPassphrase       string
+	TFGPGPassphraseFile   string
+	TFCreateProvider      string
+	TFModuleName          string
+	TFModuleProvider      string
+	TFConfirmDelete       string
+	TFDeletePlatforms     string
 
 	githubRequestTTL  time.Duration
 	githubDownloadTTL time.Duration
+	githubEvent       *GithubEvent
+
+	// release is the github release being published, possibly only partially known until resolveRelease is called
+	release GithubEventRelease
+
+	// logFormat is never "auto" once parsed
+	logLevel  zerolog.Level
+	logFormat string
+	logHTTP   bool
+
+	tfProviders           []ReleaseProvider
+	tfTargets             []RegistryTarget
+	tfProviderPlatforms   []string
+	tfRequestTTL          time.Duration
+	tfUploadTTL           time.Duration
+	tfMaxRetries          int
+	tfRetryWaitMin        time.Duration
+	tfRetryWaitMax        time.Duration
+	tfRateLimit           float64
+	tfDownloadConcurrency int
+	tfUploadConcurrency   int
+	tfCreateProvider      bool
+	tfConfirmDelete       bool
+
+	commandArgs []string
+
+	// configFile is the config file read, if any, and sources tracks which layer each value was taken from
+	configFile string
+	sources    map[string]string
+
+	// configErr holds the configuration problems reported by config explain
+	configErr error
+}
 
-	tfProviderPlatforms []string
-	tfRequestTTL        time.Duration
-	tfUploadTTL         time.Duration
+// releaseTag returns the tag of the release being published, falling back to the ref name
+func (c Config) releaseTag() string {
+	if c.release.TagName != "" {
+		return c.release.TagName
+	}
+	return c.GithubRefName
+}
+
+// releaseVersion parses the version from the release tag, which may be prefixed with "v"
+func (c Config) releaseVersion() (SemVer, error) {
+	tag := c.releaseTag()
+	v, err := ParseSemVer(strings.TrimPrefix(tag, "v"))
+	if err != nil {
+		return SemVer{}, fmt.Errorf("release tag %q is not a valid version: %w", tag, err)
+	}
+	return v, nil
 }
 
+// providerVersion returns the version published to the registry.  Commands must validate the tag with
+// releaseVersion before relying on it.
 func (c Config) providerVersion() string {
-	return strings.TrimPrefix(c.GithubRefName, "v")
+	if v, err := c.releaseVersion(); err == nil {
+		return v.String()
+	}
+	return strings.TrimPrefix(c.releaseTag(), "v")
+}
+
+// releaseDeleted returns true if the workflow was triggered by a release being deleted
+func (c Config) releaseDeleted() bool {
+	return c.GithubEventName == GithubEventNameRelease &&
+		c.githubEvent != nil &&
+		c.githubEvent.Action == GithubReleaseActionDeleted
+}
+
+// failFast returns true if the first failed platform should cancel all others
+func (c Config) failFast() bool {
+	return c.TFFailureMode == FailureModeFailFast
+}
+
+// canSign returns true if a private key has been provided to sign the shasum file with
+func (c Config) canSign() bool {
+	return c.TFGPGPrivateKey != "" || c.TFGPGPrivateKeyFile != ""
 }
 
 func (c Config) githubRepository() string {
@@ -100,98 +245,100 @@ func (c Config) tfUploadContext(ctx context.Context) (context.Context, context.C
 
 func defaultConfig() *Config {
 	c := Config{
-		GithubRequestTTL:    GithubRequestTTLDefault,
-		GithubDownloadTTL:   GithubDownloadTTLDefault,
-		TFAddress:           tfc.DefaultAddress,
-		TFRegistryName:      TFRegistryNameDefault,
-		TFProviderPlatforms: TFProviderPlatformsDefault,
-		TFRequestTTL:        TFRequestTTLDefault,
-		TFUploadTTL:         TFUploadTTLDefault,
+		GithubRequestTTL:      GithubRequestTTLDefault,
+		GithubDownloadTTL:     GithubDownloadTTLDefault,
+		LogLevel:              LogLevelDefault,
+		LogFormat:             LogFormatDefault,
+		LogHTTP:               LogHTTPDefault,
+		TFAddress:             tfc.DefaultAddress,
+		TFRegistryName:        TFRegistryNameDefault,
+		TFRequestTTL:          TFRequestTTLDefault,
+		TFUploadTTL:           TFUploadTTLDefault,
+		TFMaxRetries:          TFMaxRetriesDefault,
+		TFRetryWaitMin:        TFRetryWaitMinDefault,
+		TFRetryWaitMax:        TFRetryWaitMaxDefault,
+		TFRateLimit:           TFRateLimitDefault,
+		TFDownloadConcurrency: TFDownloadConcurrencyDefault,
+		TFUploadConcurrency:   TFUploadConcurrencyDefault,
+		TFFailureMode:         TFFailureModeDefault,
+		TFCreateProvider:      TFCreateProviderDefault,
+		TFConfirmDelete:       TFConfirmDeleteDefault,
 	}
 
 	return &c
 }
 
 func main() {
-	var (
-		err error
+	// every problem with the configuration is collected, so they may be reported at once
+	cfg, err := loadConfig()
 
-		cfg = defaultConfig()
-	)
-
-	envs := map[string]*string{
-		EnvGithubToken:           &cfg.GithubToken,
-		EnvGithubRefName:         &cfg.GithubRefName,
-		EnvGithubRepository:      &cfg.GithubRepository,
-		EnvGithubRepositoryOwner: &cfg.GithubRepositoryOwner,
-		EnvGithubRequestTTL:      &cfg.GithubRequestTTL,
-		EnvGithubDownloadTTL:     &cfg.GithubDownloadTTL,
-
-		EnvTFAddress:           &cfg.TFAddress,
-		EnvTFToken:             &cfg.TFToken,
-		EnvTFGPGKeyID:          &cfg.TFGPGKeyID,
-		EnvTFRegistryName:      &cfg.TFRegistryName,
-		EnvTFOrganizationName:  &cfg.TFOrganizationName,
-		EnvTFNamespace:         &cfg.TFNamespace,
-		EnvTFProviderName:      &cfg.TFProviderName,
-		EnvTFProviderPlatforms: &cfg.TFProviderPlatforms,
-		EnvTFRequestTTL:        &cfg.TFRequestTTL,
-		EnvTFUploadTTL:         &cfg.TFUploadTTL,
-	}
-
-	for envName, vPtr := range envs {
-		v, ok := os.LookupEnv(envName)
-		v = strings.TrimSpace(v)
-		if !ok || v == "" {
-			if *vPtr == "" {
-				err = multierror.Append(err, fmt.Errorf("missing required environment variable: %q", envName))
-			}
-		} else {
-			*vPtr = v
-		}
+	if ev, eerr := readGithubEvent(cfg.GithubEventPath); eerr != nil {
+		err = multierror.Append(err, eerr)
+	} else {
+		cfg.githubEvent = ev
 	}
 
-	if me, ok := err.(*multierror.Error); ok && me.Len() > 0 {
-		for _, e := range me.Errors {
-			fmt.Println(e.Error())
-		}
-		os.Exit(1)
+	if rel, rerr := releaseRef(cfg); rerr != nil {
+		err = multierror.Append(err, rerr)
+	} else {
+		cfg.release = rel
 	}
 
-	err = nil
+	cmdName, cmdArgs := parseCommand(os.Args[1:])
 
-	log := newLogger(cfg)
+	// a deleted release must never be re-published
+	if cmdName == CommandPublish && cfg.releaseDeleted() {
+		fmt.Printf("Release %q was deleted, running %q instead of %q\n", cfg.releaseTag(), CommandDelete, CommandPublish)
+		cmdName, cmdArgs = CommandDelete, nil
+	}
 
-	// laziness!
-	if cfg.githubRequestTTL, err = time.ParseDuration(cfg.GithubRequestTTL); err != nil {
-		log.Error().Msgf("Environment variable %q value %q is not parseable as time.Duration: %w", EnvGithubRequestTTL, cfg.GithubRequestTTL, err)
+	cmd, ok := commands[cmdName]
+	if !ok {
+		fmt.Printf("unknown command %q, expected one of: %s\n", cmdName, strings.Join(commandNames(), ", "))
 		os.Exit(1)
 	}
-	if cfg.tfRequestTTL, err = time.ParseDuration(cfg.TFRequestTTL); err != nil {
-		log.Error().Msgf("Environment variable %q value %q is not parseable as time.Duration: %w", EnvTFRequestTTL, cfg.TFRequestTTL, err)
-		os.Exit(1)
+	cfg.commandArgs = cmdArgs
+
+	if perr := cfg.parse(); perr != nil {
+		err = multierror.Append(err, perr)
 	}
-	if cfg.tfUploadTTL, err = time.ParseDuration(cfg.TFUploadTTL); err != nil {
-		log.Error().Msgf("Environment variable %q value %q is not parseable as time.Duration: %w", EnvTFUploadTTL, cfg.TFUploadTTL, err)
-		os.Exit(1)
+
+	// secrets are masked before anything, including configuration errors, may print them
+	maskConfigSecrets(cfg)
+
+	// each command has its own set of required values, checked once the targets are known
+	for _, envName := range cmd.requiredEnvs(cfg) {
+		for _, rerr := range cfg.checkRequired(envName, cmd.targeted) {
+			err = multierror.Append(err, rerr)
+		}
 	}
-	if cfg.githubDownloadTTL, err = time.ParseDuration(cfg.GithubDownloadTTL); err != nil {
-		log.Error().Msgf("Environment variable %q value %q is not parseable as time.Duration: %w", EnvGithubDownloadTTL, cfg.GithubDownloadTTL, err)
+
+	// config explain reports the problems itself, along with the values causing them
+	if err != nil && cmdName == CommandConfig {
+		cfg.configErr = err
+	} else if err != nil {
+		for _, e := range multierror.Append(nil, err).Errors {
+			annotateError("Invalid configuration", e.Error())
+		}
 		os.Exit(1)
 	}
 
-	cfg.tfProviderPlatforms = strings.Split(cfg.TFProviderPlatforms, ",")
+	log := newLogger(cfg)
 
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
 	errChan := make(chan error, 1)
 	exitCode := 0
 
-	go run(ctx, errChan, log, cfg)
+	go cmd.run(ctx, errChan, log, cfg)
 
 	select {
 	case err := <-errChan:
 		if err != nil {
+			// in the github log format the error is logged as an annotation already
 			log.Error().Err(err).Msg("Error occurred during execution")
+			if os.Getenv(EnvGithubActions) == "true" && cfg.logFormat != LogFormatGithub {
+				annotateError(fmt.Sprintf("%s failed", cmdName), err.Error())
+			}
 			exitCode = 1
 		}
 	case <-ctx.Done():
@@ -209,186 +356,604 @@ func main() {
 
 func run(ctx context.Context, done chan<- error, log zerolog.Logger, cfg *Config) {
 	var (
-		tfClient *tfc.Client
-		ghc      *github.Client
-		pv       *tfc.CreateProviderVersionResponse
-		err      error
+		targets []publishTarget
+		src     ReleaseSource
+		err     error
 	)
 
 	defer func() {
 		done <- err
 	}()
 
-	if tfClient, err = tfc.NewClient(nil); err != nil {
-		err = fmt.Errorf("error constructing TFClient: %w", err)
+	if err = resolveRelease(ctx, log, cfg); err != nil {
 		return
 	}
 
-	if ghc, err = NewGithubClient(cfg); err != nil {
-		err = fmt.Errorf("error constructing github.Client: %w", err)
+	log = log.With().Str("provider-version", cfg.providerVersion()).Logger()
+
+	if err = checkRelease(log, cfg); err != nil {
 		return
 	}
 
-	rc, err := getReleaseContext(ctx, log, ghc, cfg)
-	if err != nil {
-		err = fmt.Errorf("error parsing release context: %w", err)
+	if targets, err = newPublishTargets(ctx, log, cfg, false); err != nil {
+		return
+	}
+
+	if src, err = NewReleaseSource(log, cfg); err != nil {
+		err = fmt.Errorf("error constructing release source: %w", err)
 		return
 	}
 
-	log.Debug().Msg("Release context parsed")
+	var (
+		prepared  []providerRelease
+		summaries []PublishSummary
+		spool     *assetSpool
+	)
 
-	pvc := tfc.NewCreateProviderVersionRequest(cfg.providerVersion(), cfg.TFGPGKeyID, cfg.tfProviderPlatforms)
-	{
-		ctx, cancel := cfg.tfRequestContext(ctx)
-		defer cancel()
-		pv, err = tfClient.ProviderClient().CreateProviderVersion(ctx, cfg.TFToken, cfg.TFOrganizationName, cfg.TFRegistryName, cfg.TFNamespace, cfg.TFProviderName, pvc)
-		if err != nil {
-			err = fmt.Errorf("error creating new provider version: %w", err)
-			return
+	if prepared, err = prepareProviders(ctx, log, targets, src, cfg); err != nil {
+		return
+	}
+
+	if spool, err = newAssetSpool(cfg); err != nil {
+		return
+	}
+	defer spool.close()
+
+	// in best-effort mode a provider failing to publish does not stop the others, every provider is reported
+	for i, pr := range prepared {
+		psummaries, perr := publishProvider(ctx, pr.log, targets, spool, src, pr)
+		summaries = append(summaries, psummaries...)
+		if perr == nil {
+			pr.log.Info().Msg("Provider published")
+			continue
+		}
+		err = multierror.Append(err, fmt.Errorf("error publishing provider %q: %w", pr.cfg.TFProviderName, perr))
+		if cfg.failFast() && i < len(prepared)-1 {
+			log.Warn().Msgf("Not publishing the remaining %d provider(s) in %q mode", len(prepared)-1-i, FailureModeFailFast)
+			break
 		}
 	}
 
-	log.Info().Msg("Provider version created")
+	writeStatusTable(os.Stdout, summaries)
 
-	fileData := tfc.FileUploadRequest{
-		File:        bytes.NewBuffer(rc.Shasum.Bytes),
-		Destination: pv.Data.Links.ShasumsUpload,
-		ContentType: "binary/octet-stream",
-		Filename:    rc.Shasum.Filename,
+	// the report is written even if some uploads failed, it shows which platforms need attention
+	if serr := writePublishSummaries(summaries); serr != nil {
+		err = multierror.Append(err, serr)
+	}
+}
+
+// targetVersion is a provider version being published to a single target
+type targetVersion struct {
+	target  publishTarget
+	cfg     *Config
+	log     zerolog.Logger
+	pv      *ProviderVersionData
+	summary PublishSummary
+
+	// platforms holds the platforms that existed before this run, results those handled by it
+	platforms map[string]ProviderPlatformData
+	results   chan PlatformResult
+}
+
+// publishProvider publishes a single provider of the release to every target.  Each release asset is downloaded once
+// and uploaded to all targets needing it concurrently.  A summary is returned per target, filled in as far as
+// publishing to it got.
+func publishProvider(
+	ctx context.Context,
+	log zerolog.Logger,
+	targets []publishTarget,
+	spool *assetSpool,
+	src ReleaseSource,
+	pr providerRelease,
+) ([]PublishSummary, error) {
+	var (
+		versions []*targetVersion
+		ready    []*targetVersion
+		merr     error
+	)
+
+	for _, t := range targets {
+		tv := &targetVersion{
+			target: t,
+			cfg:    t.cfg.forProvider(pr.provider),
+			log:    t.withTarget(log),
+		}
+		versions = append(versions, tv)
+		if tv.summary.Err = prepareProviderVersion(ctx, tv, pr.rc); tv.summary.Err == nil {
+			tv.results = make(chan PlatformResult, len(pr.rc.ProviderArtifacts))
+			ready = append(ready, tv)
+		}
+	}
+
+	if len(ready) > 0 {
+		log.Info().Msgf("Preparing %d binary uploads...", len(pr.rc.ProviderArtifacts))
+
+		ctx, pool := newTransferPool(ctx, pr.cfg)
+		pool.run(ctx, pr.rc.ProviderArtifacts, func(ctx context.Context, pa ProviderArtifact) {
+			ctx, log, done := logGroup(
+				ctx,
+				log.With().Str("provider-artifact", pa.Asset.Name).Logger(),
+				fmt.Sprintf("Uploading %s", pa.ShasumFileEntry.Filename),
+			)
+			defer done()
+			uploadProviderBinary(ctx, log, pool, spool, src, pa, pr.cfg, ready)
+		})
+		pool.close()
 	}
 
-	log.Debug().Msgf("Attempting to upload %q file to %q...", rc.Shasum.Filename, pv.Data.Links.ShasumsUpload)
+	summaries := make([]PublishSummary, 0, len(versions))
+
+	for _, tv := range versions {
+		if tv.results != nil {
+			close(tv.results)
+			for res := range tv.results {
+				if errors.Is(res.Err, errFailFast) {
+					tv.log.Warn().Str("provider-artifact", res.Filename).Msg("Binary upload cancelled")
+					tv.summary.Err = multierror.Append(tv.summary.Err, fmt.Errorf("%s: %w", res.Filename, res.Err))
+				} else if res.Err != nil {
+					tv.log.Error().Err(res.Err).Str("provider-artifact", res.Filename).Msg("Error during binary upload")
+					tv.summary.Err = multierror.Append(tv.summary.Err, res.Err)
+				}
+				tv.summary.Platforms = append(tv.summary.Platforms, res)
+			}
+		}
+
+		if tv.summary.Err != nil {
+			tv.log.Error().Err(tv.summary.Err).Msg("Error publishing provider")
+			merr = multierror.Append(merr, tv.target.wrap(tv.summary.Err))
+		}
+
+		summaries = append(summaries, tv.summary)
+	}
+
+	return summaries, merr
+}
+
+// prepareProviderVersion finds or creates the provider version in a target and uploads its shasum files, leaving
+// only the binaries to be uploaded
+func prepareProviderVersion(ctx context.Context, tv *targetVersion, rc GithubReleaseContext) error {
+	var (
+		log       = tv.log
+		regClient = tv.target.regClient
+		cfg       = tv.cfg
+		created   bool
+		err       error
+	)
+
+	tv.summary = PublishSummary{
+		Target:    tv.target.name,
+		Provider:  cfg.TFProviderName,
+		KeyID:     cfg.TFGPGKeyID,
+		Source:    registrySource(regClient, cfg),
+		Protocols: rc.ProtocolVersions,
+	}
+
+	if cfg.tfCreateProvider {
+		if err = ensureProvider(ctx, log, regClient, cfg); err != nil {
+			return err
+		}
+	}
+
+	if tv.pv, created, err = findOrCreateProviderVersion(ctx, log, regClient, cfg, rc.ProtocolVersions); err != nil {
+		return err
+	}
+
+	pv := tv.pv
+	tv.summary.Version = pv
+
+	if created {
+		log.Info().Msg("Provider version created")
+	} else {
+		log.Info().Str("provider-version-id", pv.ID).Msg("Provider version already exists, resuming")
+	}
+
+	if pv.Attributes.ShasumsUploaded {
+		if err = verifyUploadedShasums(ctx, regClient, cfg, pv, rc.Shasum); err != nil {
+			return err
+		}
+		log.Info().Msgf("File %q already uploaded and matches release", rc.Shasum.Filename)
+	} else {
+		fileData := FileUploadRequest{
+			File:          bytes.NewBuffer(rc.Shasum.Bytes),
+			ContentLength: int64(len(rc.Shasum.Bytes)),
+			Destination:   pv.Links.ShasumsUpload,
+			ContentType:   "binary/octet-stream",
+			Filename:      rc.Shasum.Filename,
+		}
+
+		log.Debug().Msgf("Attempting to upload %q file to %q...", rc.Shasum.Filename, pv.Links.ShasumsUpload)
+		{
+			ctx, cancel := cfg.tfUploadContext(ctx)
+			defer cancel()
+			if err = regClient.UploadArtifact(ctx, fileData); err != nil {
+				return fmt.Errorf("error uploading %s file: %w", rc.Shasum.Filename, err)
+			}
+		}
+
+		log.Info().Msgf("File %q uploaded successfully", rc.Shasum.Filename)
+	}
+
+	if pv.Attributes.ShasumsSigUploaded {
+		log.Info().Msgf("File %q already uploaded", rc.ShasumSig.Filename)
+	} else {
+		fileData := FileUploadRequest{
+			File:          bytes.NewBuffer(rc.ShasumSig.Bytes),
+			ContentLength: int64(len(rc.ShasumSig.Bytes)),
+			Destination:   pv.Links.ShasumsSigUpload,
+			ContentType:   "binary/octet-stream",
+			Filename:      rc.ShasumSig.Filename,
+		}
+
+		log.Debug().Msgf("Attempting to upload %q file to %q...", rc.ShasumSig.Filename, pv.Links.ShasumsSigUpload)
+		{
+			ctx, cancel := cfg.tfUploadContext(ctx)
+			defer cancel()
+			if err = regClient.UploadArtifact(ctx, fileData); err != nil {
+				return fmt.Errorf("error uploading %q file: %w", rc.ShasumSig.Filename, err)
+			}
+		}
+
+		log.Info().Msgf("File %q uploaded successfully", rc.ShasumSig.Filename)
+	}
+
+	// platforms can only exist if the version did before this run
+	tv.platforms = make(map[string]ProviderPlatformData)
+	if !created {
+		var existing []ProviderPlatformData
+		{
+			ctx, cancel := cfg.tfRequestContext(ctx)
+			defer cancel()
+			if existing, err = regClient.ListProviderVersionPlatforms(ctx, cfg, pv.Attributes.Version); err != nil {
+				return fmt.Errorf("error listing existing provider version platforms: %w", err)
+			}
+		}
+		for _, p := range existing {
+			tv.platforms[platformKey(p.Attributes.Os, p.Attributes.Arch)] = p
+		}
+		log.Info().Msgf("Found %d existing provider version platforms", len(tv.platforms))
+	}
+
+	return nil
+}
+
+// ensureProvider creates the registry provider if it does not yet exist.  Only providers in the private registry
+// may be created this way.
+func ensureProvider(ctx context.Context, log zerolog.Logger, regClient *RegistryClient, cfg *Config) error {
+	var err error
+
 	{
-		ctx, cancel := cfg.tfUploadContext(ctx)
+		ctx, cancel := cfg.tfRequestContext(ctx)
 		defer cancel()
-		if err = tfc.UploadArtifact(ctx, fileData); err != nil {
-			err = fmt.Errorf("error uploading %s file: %w", rc.Shasum.Filename, err)
-			return
-		}
+		_, err = regClient.GetProvider(ctx, cfg)
+	}
 
+	if err == nil {
+		log.Debug().Msg("Registry provider exists")
+		return nil
+	} else if !isNotFound(err) {
+		return fmt.Errorf("error looking up registry provider: %w", err)
 	}
 
-	fileData = tfc.FileUploadRequest{
-		File:        bytes.NewBuffer(rc.ShasumSig.Bytes),
-		Destination: pv.Data.Links.ShasumsSigUpload,
-		ContentType: "binary/octet-stream",
-		Filename:    rc.ShasumSig.Filename,
+	if cfg.TFRegistryName != TFRegistryNameDefault {
+		return fmt.Errorf("registry provider does not exist and can only be created in the %q registry, not %q", TFRegistryNameDefault, cfg.TFRegistryName)
 	}
 
-	log.Debug().Msgf("Attempting to upload %q file to %q...", rc.ShasumSig.Filename, pv.Data.Links.ShasumsSigUpload)
+	log.Info().Msg("Registry provider does not exist, creating...")
+
+	ctx, cancel := cfg.tfRequestContext(ctx)
+	defer cancel()
+	if _, err = regClient.CreateProvider(ctx, cfg, NewCreateProviderRequest(cfg.TFNamespace, cfg.TFProviderName, cfg.TFRegistryName)); err != nil {
+		return fmt.Errorf("error creating registry provider: %w", err)
+	}
+
+	log.Info().Msg("Registry provider created")
+
+	return nil
+}
+
+// findOrCreateProviderVersion looks up the provider version in the registry, creating it only if it does not
+// already exist.  This allows a previously failed run to be resumed by simply running it again.
+func findOrCreateProviderVersion(
+	ctx context.Context,
+	log zerolog.Logger,
+	regClient *RegistryClient,
+	cfg *Config,
+	protocols []string,
+) (*ProviderVersionData, bool, error) {
+	var (
+		pv  *ProviderVersionResponse
+		err error
+	)
+
 	{
-		ctx, cancel := cfg.tfUploadContext(ctx)
+		ctx, cancel := cfg.tfRequestContext(ctx)
 		defer cancel()
-		if err = tfc.UploadArtifact(ctx, fileData); err != nil {
-			err = fmt.Errorf("error uploading %q file: %w", rc.ShasumSig.Filename, err)
-			return
+		pv, err = regClient.GetProviderVersion(ctx, cfg, cfg.providerVersion())
+	}
+
+	if err == nil {
+		if pv.Data.Attributes.KeyID != cfg.TFGPGKeyID {
+			return nil, false, fmt.Errorf(
+				"existing provider version %q was created with key-id %q, but %q is configured",
+				cfg.providerVersion(),
+				pv.Data.Attributes.KeyID,
+				cfg.TFGPGKeyID,
+			)
 		}
+		return &pv.Data, false, nil
+	} else if !isNotFound(err) {
+		return nil, false, fmt.Errorf("error looking up existing provider version: %w", err)
 	}
 
-	log.Info().Msgf("Files %q and %q uploaded successfully", rc.Shasum.Filename, rc.ShasumSig.Filename)
-	log.Info().Msgf("Preparing %d binary uploads...", len(rc.ProviderArtifacts))
+	log.Debug().Msg("Provider version does not exist yet, creating...")
+
+	pvc := tfc.NewCreateProviderVersionRequest(cfg.providerVersion(), cfg.TFGPGKeyID, protocols)
+
+	ctx, cancel := cfg.tfRequestContext(ctx)
+	defer cancel()
+	if pv, err = regClient.CreateProviderVersion(ctx, cfg, pvc); err != nil {
+		if isNotFound(err) {
+			return nil, false, fmt.Errorf(
+				"error creating new provider version, provider \"%s/%s\" may not exist in registry %q (set %q to \"true\" to create it): %w",
+				cfg.TFNamespace,
+				cfg.TFProviderName,
+				cfg.TFRegistryName,
+				EnvTFCreateProvider,
+				err,
+			)
+		}
+		return nil, false, fmt.Errorf("error creating new provider version: %w", err)
+	}
 
-	// todo: if i were a smart man, i could do this with just the channel.
-	wg := new(sync.WaitGroup)
-	wg.Add(len(rc.ProviderArtifacts))
-	errc := make(chan error, len(rc.ProviderArtifacts))
+	return &pv.Data, true, nil
+}
 
-	for _, pa := range rc.ProviderArtifacts {
-		log := log.With().Str("provider-artifact", *pa.Asset.Name).Logger()
-		go uploadProviderBinary(ctx, log, tfClient, ghc, pa, cfg, wg, errc)
+// verifyUploadedShasums ensures the shasums file stored with an existing provider version is identical to the one
+// attached to the release.  Continuing with a different file would leave the version unverifiable.
+func verifyUploadedShasums(ctx context.Context, regClient *RegistryClient, cfg *Config, pv *ProviderVersionData, sumFile ShasumFile) error {
+	if pv.Links.ShasumsDownload == "" {
+		return fmt.Errorf("provider version %q reports shasums as uploaded but provides no download link", pv.Attributes.Version)
 	}
 
-	wg.Wait()
-	close(errc)
+	ctx, cancel := cfg.tfUploadContext(ctx)
+	defer cancel()
+	stored, err := regClient.DownloadArtifact(ctx, pv.Links.ShasumsDownload)
+	if err != nil {
+		return fmt.Errorf("error downloading shasums file of existing provider version: %w", err)
+	}
 
-	for uploadErr := range errc {
-		if uploadErr != nil {
-			log.Error().Err(uploadErr).Msg("Error during binary upload")
-			err = multierror.Append(err, uploadErr)
-		}
+	if !bytes.Equal(stored, sumFile.Bytes) {
+		return fmt.Errorf("shasums file of existing provider version %q differs from release file %q, refusing to continue", pv.Attributes.Version, sumFile.Filename)
 	}
+
+	return nil
+}
+
+// platformUpload is a provider binary pending upload to a single target
+type platformUpload struct {
+	tv   *targetVersion
+	log  zerolog.Logger
+	res  PlatformResult
+	link string
 }
 
+// uploadProviderBinary publishes a single provider binary to every target.  The platform is created in each target
+// first, then the binary is downloaded once and uploaded to the targets still missing it.
 func uploadProviderBinary(
 	ctx context.Context,
 	log zerolog.Logger,
-	tfClient *tfc.Client,
-	ghc *github.Client,
+	pool *transferPool,
+	spool *assetSpool,
+	src ReleaseSource,
 	pa ProviderArtifact,
 	cfg *Config,
-	wg *sync.WaitGroup,
-	errc chan<- error,
+	versions []*targetVersion,
 ) {
-
 	var (
-		rdr io.ReadCloser
-		pvf *tfc.CreateProviderVersionPlatformResponse
-		err error
+		uploads []*platformUpload
+		err     error
 	)
 
-	// queue up cleanup
+	// every result is reported, a failure cancelling the other platforms in fail-fast mode
+	report := func(u *platformUpload) {
+		if u.res.Err != nil && ctx.Err() != nil && errors.Is(u.res.Err, context.Canceled) {
+			u.res.Err = context.Cause(ctx)
+		}
+		if u.res.Err != nil && !errors.Is(u.res.Err, errFailFast) {
+			pool.failed()
+		}
+		u.tv.results <- u.res
+	}
+
 	defer func() {
-		if rdr != nil {
-			drainReader(rdr)
+		for _, u := range uploads {
+			if u.res.Err == nil {
+				u.res.Err = err
+			}
+			report(u)
 		}
-		errc <- err
-		wg.Done()
 	}()
 
-	log.Info().Msg("Creating provider version platform...")
-
-	{
-		pvfc := tfc.NewCreateProviderVersionPlatformRequest(
-			pa.ShasumFileEntry.OS,
-			pa.ShasumFileEntry.Arch,
-			pa.ShasumFileEntry.Shasum,
-			pa.ShasumFileEntry.Filename,
-		)
+	for _, tv := range versions {
+		u := &platformUpload{
+			tv:  tv,
+			log: tv.target.withTarget(log),
+			res: PlatformResult{
+				OS:       pa.ShasumFileEntry.OS,
+				Arch:     pa.ShasumFileEntry.Arch,
+				Filename: pa.ShasumFileEntry.Filename,
+				Shasum:   pa.ShasumFileEntry.Shasum,
+			},
+		}
 
-		ctx, cancel := cfg.tfRequestContext(ctx)
-		defer cancel()
-		pvf, err = tfClient.ProviderClient().CreateProviderVersionPlatform(
-			ctx,
-			cfg.TFToken,
-			cfg.TFOrganizationName,
-			cfg.TFRegistryName,
-			cfg.TFNamespace,
-			cfg.TFProviderName,
-			pa.ShasumFileEntry.Version,
-			pvfc,
-		)
-		if err != nil {
-			err = fmt.Errorf("error creating provider version platform: %w", err)
-			return
+		// nothing is attempted once cancelled, targets which are done or failed report right away
+		if ctx.Err() != nil {
+			u.res.Err = context.Cause(ctx)
+		} else {
+			u.res.Err = preparePlatformUpload(ctx, u, pa)
+		}
+		if u.res.Err != nil || u.res.Skipped {
+			report(u)
+			continue
 		}
+		uploads = append(uploads, u)
+	}
+
+	if len(uploads) == 0 {
+		return
 	}
 
 	log.Info().Msg("Preparing to upload provider binary...")
 
+	// with retries disabled, a binary needed by a single target streams straight through unless the spool is kept as a
+	// cache, as there is nothing to send again.
+	if _, local := src.(localReleaseSource); len(uploads) == 1 && cfg.tfMaxRetries == 0 && !spool.keep && !local {
+		err = streamProviderBinary(ctx, pool, src, pa, cfg, uploads[0])
+		return
+	}
+
+	// otherwise the binary is verified against its shasum into a local file, which every target is uploaded from.
+	// being seekable, a failed upload is retried from the file rather than downloading the binary again.
+	var (
+		fpath   string
+		release func()
+	)
+	if err = pool.acquireDownload(ctx); err != nil {
+		return
+	}
 	{
 		ctx, cancel := cfg.ghDownloadContext(ctx)
-		defer cancel()
-		rdr, _, err = ghc.Repositories.DownloadReleaseAsset(ctx, cfg.GithubRepositoryOwner, cfg.githubRepository(), pa.Asset.GetID(), cleanhttp.DefaultClient())
-		if err != nil {
-			err = fmt.Errorf("error initiating download of release asset %q: %w", pa.ShasumFileEntry.Filename, err)
-			return
-		}
+		fpath, release, err = spool.fetch(ctx, log, src, pa)
+		cancel()
+	}
+	// the download slot is freed before uploading, so uploads in progress do not hold up further downloads
+	pool.releaseDownload()
+	if err != nil {
+		return
 	}
+	defer release()
 
-	{
-		fileData := tfc.FileUploadRequest{
-			File:        rdr,
-			Destination: pvf.Data.Links.ProviderBinaryUpload,
-			ContentType: "binary/octet-stream",
-			Filename:    pa.ShasumFileEntry.Filename,
+	done := make(chan struct{}, len(uploads))
+
+	for _, u := range uploads {
+		go func(u *platformUpload) {
+			defer func() { done <- struct{}{} }()
+			if u.res.Err = pool.acquireUpload(ctx); u.res.Err != nil {
+				return
+			}
+			defer pool.releaseUpload()
+			// the upload duration excludes downloading and waiting for an upload slot
+			start := time.Now()
+			f, ferr := os.Open(fpath)
+			if ferr != nil {
+				u.res.Err = fmt.Errorf("error opening downloaded release asset %q: %w", pa.ShasumFileEntry.Filename, ferr)
+				return
+			}
+			defer func() { _ = f.Close() }()
+			uploadPlatformBinary(ctx, u, pa, f, start)
+		}(u)
+	}
+
+	for range uploads {
+		<-done
+	}
+}
+
+// streamProviderBinary uploads a binary to a single target as it is downloaded.  The binary is verified against its
+// shasum while it streams, on mismatch the reader errors before the final bytes are handed off, aborting the upload.
+func streamProviderBinary(ctx context.Context, pool *transferPool, src ReleaseSource, pa ProviderArtifact, cfg *Config, u *platformUpload) error {
+	if err := pool.acquireDownload(ctx); err != nil {
+		return err
+	}
+	defer pool.releaseDownload()
+	if err := pool.acquireUpload(ctx); err != nil {
+		return err
+	}
+	defer pool.releaseUpload()
+
+	start := time.Now()
+
+	ctx, cancel := cfg.ghDownloadContext(ctx)
+	defer cancel()
+
+	rdr, err := src.Open(ctx, pa.Asset)
+	if err != nil {
+		return fmt.Errorf("error initiating download of release asset %q: %w", pa.ShasumFileEntry.Filename, err)
+	}
+	defer drainReader(rdr)
+
+	uploadPlatformBinary(ctx, u, pa, newShasumVerifyingReader(rdr, pa.ShasumFileEntry.Shasum, pa.Asset.Size), start)
+
+	return nil
+}
+
+// preparePlatformUpload checks the platform of the binary in a target, creating it if needed, and determines where
+// the binary is to be uploaded.  The result is marked skipped if the binary was already uploaded.
+func preparePlatformUpload(ctx context.Context, u *platformUpload, pa ProviderArtifact) error {
+	var (
+		log       = u.log
+		regClient = u.tv.target.regClient
+		cfg       = u.tv.cfg
+	)
+
+	if existing, ok := u.tv.platforms[platformKey(pa.ShasumFileEntry.OS, pa.ShasumFileEntry.Arch)]; ok {
+		if existing.Attributes.Shasum != pa.ShasumFileEntry.Shasum {
+			return fmt.Errorf(
+				"existing provider version platform has shasum %q, release has %q; refusing to continue",
+				existing.Attributes.Shasum,
+				pa.ShasumFileEntry.Shasum,
+			)
 		}
-		ctx, cancel := cfg.tfUploadContext(ctx)
-		defer cancel()
-		if err = tfc.UploadArtifact(ctx, fileData); err != nil {
-			err = fmt.Errorf("error uploading provider binary %q: %w", pa.ShasumFileEntry.Filename, err)
-			return
+		u.res.PlatformID = existing.ID
+		if existing.Attributes.ProviderBinaryUploaded {
+			log.Info().Msg("Provider binary already uploaded, skipping")
+			u.res.Skipped = true
+			return nil
 		}
+		log.Info().Msg("Provider version platform already exists, binary upload pending")
+		u.link = existing.Links.ProviderBinaryUpload
+		return nil
+	}
+
+	log.Info().Msg("Creating provider version platform...")
+
+	pvfc := tfc.NewCreateProviderVersionPlatformRequest(
+		pa.ShasumFileEntry.OS,
+		pa.ShasumFileEntry.Arch,
+		pa.ShasumFileEntry.Shasum,
+		pa.ShasumFileEntry.Filename,
+	)
+
+	ctx, cancel := cfg.tfRequestContext(ctx)
+	defer cancel()
+	pvf, err := regClient.CreateProviderVersionPlatform(ctx, cfg, pa.ShasumFileEntry.Version, pvfc)
+	if err != nil {
+		return fmt.Errorf("error creating provider version platform: %w", err)
 	}
+	u.res.PlatformID = pvf.Data.ID
+	u.link = pvf.Data.Links.ProviderBinaryUpload
+
+	return nil
+}
+
+// uploadPlatformBinary uploads the binary read from r to a single target, recording the outcome in its result
+func uploadPlatformBinary(ctx context.Context, u *platformUpload, pa ProviderArtifact, r io.Reader, start time.Time) {
+	fileData := FileUploadRequest{
+		File:          r,
+		ContentLength: pa.Asset.Size,
+		Destination:   u.link,
+		ContentType:   "binary/octet-stream",
+		Filename:      pa.ShasumFileEntry.Filename,
+	}
+
+	ctx, cancel := u.tv.cfg.tfUploadContext(ctx)
+	defer cancel()
+	if err := u.tv.target.regClient.UploadArtifact(ctx, fileData); err != nil {
+		u.res.Err = fmt.Errorf("error uploading provider binary %q: %w", pa.ShasumFileEntry.Filename, err)
+		return
+	}
+
+	u.res.Duration = time.Since(start)
 
-	log.Info().Msg("Provider binary successfully uploaded!")
+	u.log.Info().Dur("duration", u.res.Duration).Msg("Provider binary successfully uploaded!")
 }
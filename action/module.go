@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dcarbone/go-tfc"
+	"github.com/rs/zerolog"
+)
+
+const (
+	ModuleVersionStatusOK      = "ok"
+	ModuleVersionStatusErrored = "errored"
+
+	// moduleStatusPollInterval is how often the module version status is fetched after the tarball is uploaded
+	moduleStatusPollInterval = 5 * time.Second
+)
+
+// runModule publishes a module tarball attached to the release as a new version of a non-VCS private registry module.
+// The module itself must already exist in the registry.
+func runModule(ctx context.Context, done chan<- error, log zerolog.Logger, cfg *Config) {
+	var (
+		regClient *RegistryClient
+		src       ReleaseSource
+		spool     *assetSpool
+		asset     ReleaseAsset
+		mv        *tfc.CreateModuleVersionResponse
+		fpath     string
+		release   func()
+		f         *os.File
+		err       error
+	)
+
+	defer func() {
+		done <- err
+	}()
+
+	if err = resolveRelease(ctx, log, cfg); err != nil {
+		return
+	}
+
+	log = log.With().
+		Str("module", fmt.Sprintf("%s/%s/%s", cfg.TFNamespace, cfg.TFModuleName, cfg.TFModuleProvider)).
+		Str("module-version", cfg.providerVersion()).
+		Logger()
+
+	if err = checkRelease(log, cfg); err != nil {
+		return
+	}
+
+	if regClient, err = NewRegistryClient(ctx, log, cfg); err != nil {
+		err = fmt.Errorf("error constructing RegistryClient: %w", err)
+		return
+	}
+
+	if src, err = NewReleaseSource(log, cfg); err != nil {
+		err = fmt.Errorf("error constructing release source: %w", err)
+		return
+	}
+
+	if spool, err = newAssetSpool(cfg); err != nil {
+		return
+	}
+	defer spool.close()
+
+	if asset, err = findModuleAsset(ctx, log, src, cfg); err != nil {
+		return
+	}
+
+	log.Info().Object("asset", asset).Msg("Module tarball found")
+
+	if status, ok, serr := moduleVersionStatus(ctx, regClient, cfg); serr != nil {
+		err = serr
+		return
+	} else if ok && status.Status == ModuleVersionStatusOK {
+		log.Info().Msgf("Module version %q already published", status.Version)
+		return
+	} else if ok {
+		// the upload link is only handed out when the version is created
+		err = fmt.Errorf("module version %q already exists with status %q, delete it before re-running", status.Version, status.Status)
+		return
+	}
+
+	{
+		ctx, cancel := cfg.tfRequestContext(ctx)
+		defer cancel()
+		if mv, err = regClient.CreateModuleVersion(ctx, cfg, tfc.NewCreateModuleVersionRequest(cfg.providerVersion())); err != nil {
+			err = fmt.Errorf("error creating module version: %w", err)
+			return
+		}
+	}
+
+	log.Info().Str("module-version-id", mv.Data.ID).Msg("Module version created")
+
+	if mv.Data.Links.Upload == "" {
+		err = fmt.Errorf("module version %q response did not include an upload link", mv.Data.ID)
+		return
+	}
+
+	// the tarball is downloaded first, so the upload is bound by its own ttl and may be retried from the file
+	{
+		ctx, cancel := cfg.ghDownloadContext(ctx)
+		fpath, release, err = spool.fetchAsset(ctx, log, src, asset, "")
+		cancel()
+		if err != nil {
+			return
+		}
+	}
+	defer release()
+
+	if f, err = os.Open(fpath); err != nil {
+		err = fmt.Errorf("error opening downloaded asset %q: %w", asset.Name, err)
+		return
+	}
+	defer func() { _ = f.Close() }()
+
+	{
+		fileData := FileUploadRequest{
+			File:          f,
+			ContentLength: asset.Size,
+			Destination:   mv.Data.Links.Upload,
+			ContentType:   "application/x-gzip",
+			Filename:      asset.Name,
+		}
+
+		log.Debug().Msgf("Attempting to upload %q file to %q...", asset.Name, mv.Data.Links.Upload)
+
+		ctx, cancel := cfg.tfUploadContext(ctx)
+		defer cancel()
+		if err = regClient.UploadArtifact(ctx, fileData); err != nil {
+			err = fmt.Errorf("error uploading %s file: %w", asset.Name, err)
+			return
+		}
+	}
+
+	log.Info().Msgf("File %q uploaded successfully", asset.Name)
+
+	if err = waitForModuleVersion(ctx, log, regClient, cfg); err != nil {
+		return
+	}
+
+	log.Info().Msg("Module version published")
+}
+
+// findModuleAsset locates the module tarball among the release assets.  When more than one tarball is attached, the
+// one prefixed with the module name is chosen.
+func findModuleAsset(ctx context.Context, log zerolog.Logger, src ReleaseSource, cfg *Config) (ReleaseAsset, error) {
+	var (
+		assets   []ReleaseAsset
+		tarballs []ReleaseAsset
+		err      error
+	)
+
+	{
+		ctx, cancel := cfg.ghRequestContext(ctx)
+		defer cancel()
+		if assets, err = src.Assets(ctx, log); err != nil {
+			return ReleaseAsset{}, fmt.Errorf("error listing release assets: %w", err)
+		}
+	}
+
+	for _, asset := range assets {
+		if strings.HasSuffix(asset.Name, ".tar.gz") || strings.HasSuffix(asset.Name, ".tgz") {
+			tarballs = append(tarballs, asset)
+		}
+	}
+
+	switch len(tarballs) {
+	case 0:
+		return ReleaseAsset{}, errors.New("no .tar.gz asset found in release")
+	case 1:
+		return tarballs[0], nil
+	}
+
+	var found []ReleaseAsset
+	for _, asset := range tarballs {
+		if strings.HasPrefix(asset.Name, cfg.TFModuleName) {
+			found = append(found, asset)
+		}
+	}
+	if len(found) != 1 {
+		return ReleaseAsset{}, fmt.Errorf("found %d .tar.gz assets in release, unable to determine which belongs to module %q", len(tarballs), cfg.TFModuleName)
+	}
+
+	return found[0], nil
+}
+
+// moduleVersionStatus returns the registry's status of the version being published, if the version exists
+func moduleVersionStatus(ctx context.Context, regClient *RegistryClient, cfg *Config) (ModuleVersionStatus, bool, error) {
+	ctx, cancel := cfg.tfRequestContext(ctx)
+	defer cancel()
+
+	mod, err := regClient.GetModule(ctx, cfg)
+	if isNotFound(err) {
+		return ModuleVersionStatus{}, false, fmt.Errorf("module %q does not exist in registry %q: %w", cfg.TFModuleName, cfg.TFRegistryName, err)
+	} else if err != nil {
+		return ModuleVersionStatus{}, false, fmt.Errorf("error fetching module: %w", err)
+	}
+
+	for _, status := range mod.Data.Attributes.VersionStatuses {
+		if status.Version == cfg.providerVersion() {
+			return status, true, nil
+		}
+	}
+
+	return ModuleVersionStatus{}, false, nil
+}
+
+// waitForModuleVersion polls the module until the registry has finished ingesting the uploaded tarball, bounded by
+// the upload ttl.
+func waitForModuleVersion(ctx context.Context, log zerolog.Logger, regClient *RegistryClient, cfg *Config) error {
+	ctx, cancel := cfg.tfUploadContext(ctx)
+	defer cancel()
+
+	ticker := time.NewTicker(moduleStatusPollInterval)
+	defer ticker.Stop()
+
+	for {
+		status, ok, err := moduleVersionStatus(ctx, regClient, cfg)
+		if err != nil {
+			return err
+		}
+
+		if ok {
+			log.Debug().Str("status", status.Status).Msg("Module version status fetched")
+			if status.Status == ModuleVersionStatusOK {
+				return nil
+			} else if status.Status == ModuleVersionStatusErrored || strings.HasSuffix(status.Status, "failed") {
+				return fmt.Errorf("module version %q failed with status %q: %s", status.Version, status.Status, status.Error)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out waiting for module version %q to become %q: %w", cfg.providerVersion(), ModuleVersionStatusOK, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/dcarbone/go-tfc"
+	"github.com/rs/zerolog"
+)
+
+const (
+	PlanFormatTable = "table"
+	PlanFormatJSON  = "json"
+
+	// planPendingLink is used in place of upload links the registry only hands out once the resource is created
+	planPendingLink = "(upload link returned by registry)"
+)
+
+type (
+	PlanOperation struct {
+		Method      string      `json:"method"`
+		URL         string      `json:"url"`
+		Description string      `json:"description"`
+		Body        interface{} `json:"body,omitempty"`
+	}
+
+	// Plan describes every modifying registry call publish would make for the release
+	Plan struct {
+		Target     string          `json:"target,omitempty"`
+		Provider   string          `json:"provider"`
+		Version    string          `json:"version"`
+		KeyID      string          `json:"key-id"`
+		Protocols  []string        `json:"protocols"`
+		Operations []PlanOperation `json:"operations"`
+	}
+)
+
+// runPlan runs the discovery and validation of publish and prints the registry operations it would execute.  The
+// registry clients are read only, anything other than a GET request fails.  With more than one provider in the release
+// or more than one target, the JSON output is a list of plans.
+//
+// Usage:
+//
+//	plan [table|json] [output file]
+func runPlan(ctx context.Context, done chan<- error, log zerolog.Logger, cfg *Config) {
+	var (
+		targets    []publishTarget
+		src        ReleaseSource
+		prepared   []providerRelease
+		plans      []*Plan
+		operations int
+		format               = PlanFormatTable
+		out        io.Writer = os.Stdout
+		err        error
+	)
+
+	defer func() {
+		done <- err
+	}()
+
+	if len(cfg.commandArgs) > 0 {
+		format = cfg.commandArgs[0]
+	}
+	if format != PlanFormatTable && format != PlanFormatJSON {
+		err = fmt.Errorf("unknown plan format %q, expected %q or %q", format, PlanFormatTable, PlanFormatJSON)
+		return
+	}
+
+	if err = resolveRelease(ctx, log, cfg); err != nil {
+		return
+	}
+
+	log = log.With().Str("provider-version", cfg.providerVersion()).Logger()
+
+	if err = checkRelease(log, cfg); err != nil {
+		return
+	}
+
+	if targets, err = newPublishTargets(ctx, log, cfg, true); err != nil {
+		return
+	}
+
+	if src, err = NewReleaseSource(log, cfg); err != nil {
+		err = fmt.Errorf("error constructing release source: %w", err)
+		return
+	}
+
+	if prepared, err = prepareProviders(ctx, log, targets, src, cfg); err != nil {
+		return
+	}
+
+	for _, t := range targets {
+		for _, pr := range prepared {
+			var plan *Plan
+			if plan, err = buildPlan(ctx, t.regClient, t.cfg.forProvider(pr.provider), pr.rc); err != nil {
+				err = fmt.Errorf("error planning provider %q%s: %w", pr.cfg.TFProviderName, t.describe(), err)
+				return
+			}
+			plan.Target = t.name
+			plans = append(plans, plan)
+			operations += len(plan.Operations)
+		}
+	}
+
+	if len(cfg.commandArgs) > 1 {
+		f, ferr := os.Create(cfg.commandArgs[1])
+		if ferr != nil {
+			err = fmt.Errorf("error creating plan file: %w", ferr)
+			return
+		}
+		defer func() { _ = f.Close() }()
+		out = f
+	}
+
+	if format == PlanFormatJSON {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		// a release with a single provider and target keeps the plain object format
+		if len(plans) == 1 {
+			err = enc.Encode(plans[0])
+		} else {
+			err = enc.Encode(plans)
+		}
+	} else {
+		for i, plan := range plans {
+			if i > 0 {
+				_, _ = fmt.Fprintln(out)
+			}
+			if err = writePlanTable(out, plan); err != nil {
+				break
+			}
+		}
+	}
+
+	if err != nil {
+		err = fmt.Errorf("error writing plan: %w", err)
+		return
+	}
+
+	log.Info().Msgf("Plan contains %d operations", operations)
+}
+
+// buildPlan compares the release with the current state of the registry.  Conflicts publish would refuse to resolve
+// are returned as errors.
+func buildPlan(ctx context.Context, regClient *RegistryClient, cfg *Config, rc GithubReleaseContext) (*Plan, error) {
+	var (
+		version   = cfg.providerVersion()
+		pv        *ProviderVersionData
+		platforms = make(map[string]ProviderPlatformData)
+
+		plan = Plan{
+			Provider:  fmt.Sprintf("%s/%s", cfg.TFNamespace, cfg.TFProviderName),
+			Version:   version,
+			KeyID:     cfg.TFGPGKeyID,
+			Protocols: rc.ProtocolVersions,
+		}
+	)
+
+	add := func(method, link, description string, body interface{}) {
+		plan.Operations = append(plan.Operations, PlanOperation{Method: method, URL: redact(link), Description: description, Body: body})
+	}
+
+	if cfg.tfCreateProvider {
+		ctx, cancel := cfg.tfRequestContext(ctx)
+		_, err := regClient.GetProvider(ctx, cfg)
+		cancel()
+		if isNotFound(err) {
+			add(
+				http.MethodPost,
+				regClient.apiURL(path.Join("organizations", url.PathEscape(cfg.TFOrganizationName), "registry-providers")),
+				fmt.Sprintf("create provider %s in registry %q", plan.Provider, cfg.TFRegistryName),
+				NewCreateProviderRequest(cfg.TFNamespace, cfg.TFProviderName, cfg.TFRegistryName),
+			)
+		} else if err != nil {
+			return nil, fmt.Errorf("error looking up registry provider: %w", err)
+		}
+	}
+
+	{
+		ctx, cancel := cfg.tfRequestContext(ctx)
+		resp, err := regClient.GetProviderVersion(ctx, cfg, version)
+		cancel()
+		if err == nil {
+			if resp.Data.Attributes.KeyID != cfg.TFGPGKeyID {
+				return nil, fmt.Errorf("existing provider version %q was created with key-id %q, but %q is configured", version, resp.Data.Attributes.KeyID, cfg.TFGPGKeyID)
+			}
+			pv = &resp.Data
+		} else if !isNotFound(err) {
+			return nil, fmt.Errorf("error looking up existing provider version: %w", err)
+		}
+	}
+
+	shasumsLink, sigLink := planPendingLink, planPendingLink
+
+	if pv == nil {
+		add(
+			http.MethodPost,
+			regClient.apiURL(regClient.providerRoute(cfg, "versions")),
+			fmt.Sprintf("create version %s with key-id %s and protocols %s", version, cfg.TFGPGKeyID, strings.Join(rc.ProtocolVersions, ",")),
+			tfc.NewCreateProviderVersionRequest(version, cfg.TFGPGKeyID, rc.ProtocolVersions),
+		)
+	} else {
+		if pv.Attributes.ShasumsUploaded {
+			if err := verifyUploadedShasums(ctx, regClient, cfg, pv, rc.Shasum); err != nil {
+				return nil, err
+			}
+		}
+		shasumsLink, sigLink = pv.Links.ShasumsUpload, pv.Links.ShasumsSigUpload
+
+		ctx, cancel := cfg.tfRequestContext(ctx)
+		existing, err := regClient.ListProviderVersionPlatforms(ctx, cfg, version)
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("error listing existing provider version platforms: %w", err)
+		}
+		for _, p := range existing {
+			platforms[platformKey(p.Attributes.Os, p.Attributes.Arch)] = p
+		}
+	}
+
+	if pv == nil || !pv.Attributes.ShasumsUploaded {
+		add(http.MethodPut, shasumsLink, fmt.Sprintf("upload %s (%d bytes)", rc.Shasum.Filename, len(rc.Shasum.Bytes)), nil)
+	}
+	if pv == nil || !pv.Attributes.ShasumsSigUploaded {
+		add(http.MethodPut, sigLink, fmt.Sprintf("upload %s (%d bytes)", rc.ShasumSig.Filename, len(rc.ShasumSig.Bytes)), nil)
+	}
+
+	for _, pa := range rc.ProviderArtifacts {
+		fe := pa.ShasumFileEntry
+		key := platformKey(fe.OS, fe.Arch)
+		uploadLink := planPendingLink
+
+		if p, ok := platforms[key]; ok {
+			if p.Attributes.Shasum != fe.Shasum {
+				return nil, fmt.Errorf("existing provider version platform %q has shasum %q, release has %q", key, p.Attributes.Shasum, fe.Shasum)
+			}
+			if p.Attributes.ProviderBinaryUploaded {
+				continue
+			}
+			uploadLink = p.Links.ProviderBinaryUpload
+		} else {
+			add(
+				http.MethodPost,
+				regClient.apiURL(regClient.providerRoute(cfg, "versions", version, "platforms")),
+				fmt.Sprintf("create platform %s with shasum %s and filename %s", key, fe.Shasum, fe.Filename),
+				tfc.NewCreateProviderVersionPlatformRequest(fe.OS, fe.Arch, fe.Shasum, fe.Filename),
+			)
+		}
+
+		add(http.MethodPut, uploadLink, fmt.Sprintf("upload %s (%d bytes)", pa.Asset.Name, pa.Asset.Size), nil)
+	}
+
+	return &plan, nil
+}
+
+func writePlanTable(w io.Writer, plan *Plan) error {
+	if plan.Target != "" {
+		_, _ = fmt.Fprintf(w, "Target:    %s\n", plan.Target)
+	}
+	_, _ = fmt.Fprintf(w, "Provider:  %s\nVersion:   %s\nKey ID:    %s\nProtocols: %s\n\n", plan.Provider, plan.Version, plan.KeyID, strings.Join(plan.Protocols, ","))
+
+	if len(plan.Operations) == 0 {
+		_, err := fmt.Fprintln(w, "No changes, the version is already published.")
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "METHOD\tURL\tDESCRIPTION")
+	for _, op := range plan.Operations {
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\n", op.Method, op.URL, op.Description)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+const (
+	FailureModeBestEffort = "best-effort"
+	FailureModeFailFast   = "fail-fast"
+)
+
+// errFailFast is the result of every platform cancelled, or never attempted, after another one failed in fail-fast mode
+var errFailFast = errors.New("cancelled after another platform failed")
+
+// transferPool runs the binary transfers of a provider with a bounded number of workers, each handling one release
+// asset at a time.  Downloads and uploads are bounded independently, as a single download may be uploaded to several
+// targets, so there are as many workers as the larger of the two limits.
+type transferPool struct {
+	workers   int
+	downloads chan struct{}
+	uploads   chan struct{}
+	failFast  bool
+	cancel    context.CancelCauseFunc
+}
+
+// newTransferPool returns a pool along with the context its transfers must use, cancelled in fail-fast mode once a
+// transfer fails.  close must be called once the pool is no longer used.
+func newTransferPool(ctx context.Context, cfg *Config) (context.Context, *transferPool) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	p := transferPool{
+		workers:   max(cfg.tfDownloadConcurrency, cfg.tfUploadConcurrency),
+		downloads: make(chan struct{}, cfg.tfDownloadConcurrency),
+		uploads:   make(chan struct{}, cfg.tfUploadConcurrency),
+		failFast:  cfg.failFast(),
+		cancel:    cancel,
+	}
+	return ctx, &p
+}
+
+// run calls fn for every artifact, with at most p.workers calls running at once, returning once all have returned
+func (p *transferPool) run(ctx context.Context, artifacts []ProviderArtifact, fn func(ctx context.Context, pa ProviderArtifact)) {
+	var (
+		workers  = min(p.workers, len(artifacts))
+		jobs     = make(chan ProviderArtifact)
+		finished = make(chan struct{}, workers)
+	)
+
+	for i := 0; i < workers; i++ {
+		go func() {
+			for pa := range jobs {
+				fn(ctx, pa)
+			}
+			finished <- struct{}{}
+		}()
+	}
+
+	// every artifact is handed out, even once cancelled, so each one is reported
+	for _, pa := range artifacts {
+		jobs <- pa
+	}
+	close(jobs)
+
+	for i := 0; i < workers; i++ {
+		<-finished
+	}
+}
+
+// acquireDownload blocks until a download may start, or ctx is done
+func (p *transferPool) acquireDownload(ctx context.Context) error {
+	select {
+	case p.downloads <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	}
+}
+
+func (p *transferPool) releaseDownload() {
+	<-p.downloads
+}
+
+// acquireUpload blocks until an upload may start, or ctx is done
+func (p *transferPool) acquireUpload(ctx context.Context) error {
+	select {
+	case p.uploads <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	}
+}
+
+func (p *transferPool) releaseUpload() {
+	<-p.uploads
+}
+
+// failed cancels every other transfer of the pool in fail-fast mode
+func (p *transferPool) failed() {
+	if p.failFast {
+		p.cancel(errFailFast)
+	}
+}
+
+func (p *transferPool) close() {
+	p.cancel(nil)
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/rs/zerolog"
+)
+
+const providerAssetPrefix = "terraform-provider-"
+
+// ReleaseProvider is a provider published from the release.  Namespace overrides the namespace of the target, if set.
+type ReleaseProvider struct {
+	Name      string
+	Namespace string
+}
+
+// providerRelease is everything needed to publish a single provider of the release
+type providerRelease struct {
+	provider ReleaseProvider
+	cfg      *Config
+	log      zerolog.Logger
+	rc       GithubReleaseContext
+}
+
+// parseProviders parses TF_PROVIDERS, a comma-separated list of "name" or "name=namespace" entries.  Providers
+// without a namespace are published to the namespace of the target.
+func parseProviders(raw string) ([]ReleaseProvider, error) {
+	var (
+		out  []ReleaseProvider
+		seen = make(map[string]struct{})
+		merr error
+	)
+
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		name, namespace, ok := strings.Cut(entry, "=")
+		name, namespace = strings.TrimSpace(name), strings.TrimSpace(namespace)
+
+		if !TFProviderNameRe.MatchString(name) {
+			merr = multierror.Append(merr, fmt.Errorf("provider %q is not a valid name, it must match %q", name, TFProviderNameRe.String()))
+			continue
+		} else if ok && !TFOrganizationNameRe.MatchString(namespace) {
+			merr = multierror.Append(merr, fmt.Errorf("namespace %q of provider %q is not a valid name, it must match %q", namespace, name, TFOrganizationNameRe.String()))
+			continue
+		}
+
+		if _, ok := seen[name]; ok {
+			merr = multierror.Append(merr, fmt.Errorf("provider %q is listed more than once", name))
+			continue
+		}
+		seen[name] = struct{}{}
+
+		out = append(out, ReleaseProvider{Name: name, Namespace: namespace})
+	}
+
+	return out, merr
+}
+
+// providers returns the providers published from the release, a single one unless TF_PROVIDERS is set
+func (c Config) providers() []ReleaseProvider {
+	if len(c.tfProviders) > 0 {
+		return c.tfProviders
+	}
+	return []ReleaseProvider{{Name: c.TFProviderName}}
+}
+
+// providersHaveNamespaces returns true if every provider in TF_PROVIDERS has its own namespace
+func (c Config) providersHaveNamespaces() bool {
+	for _, p := range c.tfProviders {
+		if p.Namespace == "" {
+			return false
+		}
+	}
+	return len(c.tfProviders) > 0
+}
+
+// forProvider returns a copy of the config scoped to a single provider.  Everything downstream of the release
+// assets works with one provider at a time through TFProviderName and TFNamespace.
+func (c *Config) forProvider(p ReleaseProvider) *Config {
+	pc := *c
+	pc.TFProviderName = p.Name
+	if p.Namespace != "" {
+		pc.TFNamespace = p.Namespace
+	}
+	return &pc
+}
+
+// providerOfAsset returns the provider name of an asset named "terraform-provider-NAME_...", or false if the name
+// has no such prefix
+func providerOfAsset(name string) (string, bool) {
+	if !strings.HasPrefix(name, providerAssetPrefix) {
+		return "", false
+	}
+	provider, _, ok := strings.Cut(strings.TrimPrefix(name, providerAssetPrefix), "_")
+	return provider, ok && provider != ""
+}
+
+// groupReleaseAssets assigns the release assets to the providers being published.  A single provider is handed every
+// asset, as before, with any naming problems reported by lintReleaseContext.  With TF_PROVIDERS set, assets are grouped
+// by their name prefix and the returned error lists those which belong to none of the providers.
+func groupReleaseAssets(cfg *Config, assets []ReleaseAsset) (map[string][]ReleaseAsset, error) {
+	groups := make(map[string][]ReleaseAsset)
+
+	if len(cfg.tfProviders) == 0 {
+		groups[cfg.TFProviderName] = assets
+		return groups, nil
+	}
+
+	for _, p := range cfg.tfProviders {
+		groups[p.Name] = nil
+	}
+
+	var merr error
+
+	for _, asset := range assets {
+		if strings.HasPrefix(asset.Name, sourceCodeArtifactName) {
+			continue
+		}
+		name, ok := providerOfAsset(asset.Name)
+		if _, configured := groups[name]; ok && configured {
+			groups[name] = append(groups[name], asset)
+		} else if isReleaseArtifact(asset.Name) {
+			merr = multierror.Append(merr, fmt.Errorf("asset %q does not belong to any of the providers in %q", asset.Name, EnvTFProviders))
+		}
+	}
+
+	return groups, merr
+}
+
+// isReleaseArtifact returns true for the asset names readReleaseContext knows about
+func isReleaseArtifact(name string) bool {
+	return strings.HasSuffix(name, shasumSuffix) ||
+		strings.HasSuffix(name, shasumSigSuffix) ||
+		strings.HasSuffix(name, manifestSuffix) ||
+		strings.HasSuffix(name, zipSuffix)
+}
+
+// providerFindings splits err into its individual problems, naming the provider they belong to when the release
+// contains more than one
+func providerFindings(cfg *Config, p ReleaseProvider, err error) []error {
+	errs := multierror.Append(nil, err).Errors
+	if len(cfg.tfProviders) == 0 {
+		return errs
+	}
+	for i, e := range errs {
+		errs[i] = fmt.Errorf("provider %q: %w", p.Name, e)
+	}
+	return errs
+}
+
+// listReleaseAssets lists the release assets once, to be shared by all providers
+func listReleaseAssets(ctx context.Context, log zerolog.Logger, src ReleaseSource, cfg *Config) ([]ReleaseAsset, error) {
+	ctx, cancel := cfg.ghRequestContext(ctx)
+	defer cancel()
+	assets, err := src.Assets(ctx, log)
+	if err != nil {
+		return nil, fmt.Errorf("error listing release assets: %w", err)
+	}
+	return assets, nil
+}
+
+// prepareProviders reads, lints and verifies the signature of every provider in the release, for every target.
+// Nothing is published unless all of them are valid, so the problems of every provider are returned together.
+func prepareProviders(
+	ctx context.Context,
+	log zerolog.Logger,
+	targets []publishTarget,
+	src ReleaseSource,
+	cfg *Config,
+) ([]providerRelease, error) {
+	assets, err := listReleaseAssets(ctx, log, src, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	groups, findings := groupReleaseAssets(cfg, assets)
+
+	var prepared []providerRelease
+
+	for _, p := range cfg.providers() {
+		pr := providerRelease{
+			provider: p,
+			cfg:      cfg.forProvider(p),
+			log:      log.With().Str("provider-name", p.Name).Logger(),
+		}
+
+		if pr.rc, err = getReleaseContext(ctx, pr.log, src, pr.cfg, groups[p.Name]); err != nil {
+			findings = multierror.Append(findings, providerFindings(cfg, p, err)...)
+			continue
+		}
+
+		pr.log.Debug().Msg("Release context parsed")
+
+		// nothing is created in the registry until the signature is known to be good
+		verified := true
+		for _, t := range targets {
+			tcfg := t.cfg.forProvider(p)
+			if err = verifyReleaseSignature(ctx, pr.log, t.regClient, tcfg, pr.rc); err != nil {
+				findings = multierror.Append(findings, providerFindings(cfg, p, fmt.Errorf("error verifying release signature%s: %w", t.describe(), err))...)
+				verified = false
+				continue
+			}
+			pr.log.Info().Msgf("Signature %q verified with key %q%s", pr.rc.ShasumSig.Filename, tcfg.TFGPGKeyID, t.describe())
+		}
+
+		if verified {
+			prepared = append(prepared, pr)
+		}
+	}
+
+	if findings != nil {
+		return nil, fmt.Errorf("error parsing release context: %w", reportFindings(log, findings))
+	}
+
+	return prepared, nil
+}
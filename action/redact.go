@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	redacted            = "***"
+	minMaskedLineLength = 8
+)
+
+// redactQueryRe matches the query string of a url, which may hold the signature of a pre-signed link.  It stops at a
+// backslash, so the escape sequence ending a url within a json string is kept.
+var redactQueryRe = regexp.MustCompile(`(https?://[^\s"'?#\\]+)\?[^\s"'#\\]+`)
+
+// secrets holds every value registered with maskSecret
+var secrets secretSet
+
+type secretSet struct {
+	mu     sync.RWMutex
+	values []string
+	seen   map[string]bool
+}
+
+// maskSecret registers values to be replaced by redact.  When run by a workflow the runner is also asked to mask them,
+// so they are hidden from anything else written to the workflow log.  Multi-line values, such as a private key, are
+// masked line by line as the runner matches single lines only, skipping short lines which could match anything.
+func maskSecret(values ...string) {
+	secrets.mu.Lock()
+	defer secrets.mu.Unlock()
+
+	if secrets.seen == nil {
+		secrets.seen = make(map[string]bool)
+	}
+
+	for _, v := range values {
+		lines := strings.Split(v, "\n")
+		for _, line := range lines {
+			line = strings.TrimSpace(line)
+			if line == "" || secrets.seen[line] || (len(lines) > 1 && len(line) < minMaskedLineLength) {
+				continue
+			}
+			secrets.seen[line] = true
+			secrets.values = append(secrets.values, line)
+			if os.Getenv(EnvGithubActions) == "true" {
+				fmt.Printf("::add-mask::%s\n", escapeCommandData(line))
+			}
+		}
+	}
+
+	// longer values first, so a secret containing another is replaced whole
+	sort.Slice(secrets.values, func(i, j int) bool { return len(secrets.values[i]) > len(secrets.values[j]) })
+}
+
+// maskConfigSecrets registers every secret setting, along with the token of each target
+func maskConfigSecrets(cfg *Config) {
+	for _, s := range cfg.settings() {
+		if s.secret {
+			maskSecret(*s.value)
+		}
+	}
+	for _, t := range cfg.tfTargets {
+		maskSecret(t.Token)
+	}
+}
+
+// redact replaces every registered secret in s, and strips the query string of every url in it
+func redact(s string) string {
+	secrets.mu.RLock()
+	for _, v := range secrets.values {
+		s = strings.ReplaceAll(s, v, redacted)
+	}
+	secrets.mu.RUnlock()
+
+	if strings.Contains(s, "?") {
+		s = redactQueryRe.ReplaceAllString(s, "${1}?"+redacted)
+	}
+
+	return s
+}
+
+// redactingWriter redacts every log event before handing it to the next writer
+type redactingWriter struct {
+	next io.Writer
+}
+
+func newRedactingWriter(w io.Writer) zerolog.LevelWriter {
+	return redactingWriter{next: w}
+}
+
+func (w redactingWriter) Write(p []byte) (int, error) {
+	return w.WriteLevel(zerolog.NoLevel, p)
+}
+
+func (w redactingWriter) WriteLevel(level zerolog.Level, p []byte) (int, error) {
+	var (
+		b   = []byte(redact(string(p)))
+		err error
+	)
+	if lw, ok := w.next.(zerolog.LevelWriter); ok {
+		_, err = lw.WriteLevel(level, b)
+	} else {
+		_, err = w.next.Write(b)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
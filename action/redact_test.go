@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+// withSecrets registers values for the duration of a test
+func withSecrets(t *testing.T, values ...string) {
+	t.Helper()
+	t.Setenv(EnvGithubActions, "")
+	maskSecret(values...)
+	t.Cleanup(func() {
+		secrets.mu.Lock()
+		secrets.values, secrets.seen = nil, nil
+		secrets.mu.Unlock()
+	})
+}
+
+func TestRedact(t *testing.T) {
+	withSecrets(t, "tfc-token-value", "tfc-token-value-longer", "-----BEGIN KEY-----\nc2VjcmV0LWtleS1ib2R5\nab\n-----END KEY-----")
+
+	tests := []struct {
+		name     string
+		in       string
+		expected string
+	}{
+		{name: "plain", in: "nothing to see", expected: "nothing to see"},
+		{name: "url", in: "PUT https://archivist.example.com/v1/object/abc?sig=s3cr3t&exp=1 failed", expected: "PUT https://archivist.example.com/v1/object/abc?*** failed"},
+		{name: "url-fragment", in: "https://example.com/a?sig=s3cr3t#top", expected: "https://example.com/a?***#top"},
+		{name: "url-quoted", in: `link "https://example.com/a?sig=s3cr3t" expired`, expected: `link "https://example.com/a?***" expired`},
+		{name: "url-without-query", in: "see https://example.com/a, or not?", expected: "see https://example.com/a, or not?"},
+		{name: "url-json-escaped", in: `{"error":"Put \"https://example.com/a?sig=s3cr3t\": EOF"}`, expected: `{"error":"Put \"https://example.com/a?***\": EOF"}`},
+		{name: "secret", in: "token tfc-token-value rejected", expected: "token *** rejected"},
+		{name: "secret-longest-first", in: "token tfc-token-value-longer rejected", expected: "token *** rejected"},
+		{name: "secret-multi-line", in: "key c2VjcmV0LWtleS1ib2R5 ab", expected: "key *** ab"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := redact(tt.in); got != tt.expected {
+				t.Fatalf("redact() = %q, expected %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRedactingWriterJSON(t *testing.T) {
+	withSecrets(t, "tfc-token-value")
+
+	var buf bytes.Buffer
+	log := zerolog.New(newRedactingWriter(&buf))
+	log.Error().
+		Err(errors.New(`Put "https://archivist.example.com/v1/object/abc?sig=s3cr3t": connection reset`)).
+		Str("url", "https://archivist.example.com/v1/object/abc?sig=s3cr3t").
+		Str("token", "tfc-token-value").
+		Msg("Upload failed")
+
+	out := buf.Bytes()
+	if !json.Valid(out) {
+		t.Fatalf("redacted log line is not valid json: %s", out)
+	}
+	if bytes.Contains(out, []byte("s3cr3t")) || bytes.Contains(out, []byte("tfc-token-value")) {
+		t.Fatalf("log line not redacted: %s", out)
+	}
+
+	var fields map[string]string
+	if err := json.Unmarshal(out, &fields); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"error":   `Put "https://archivist.example.com/v1/object/abc?***": connection reset`,
+		"url":     "https://archivist.example.com/v1/object/abc?***",
+		"token":   redacted,
+		"message": "Upload failed",
+	}
+	for k, v := range expected {
+		if fields[k] != v {
+			t.Fatalf("field %q = %q, expected %q", k, fields[k], v)
+		}
+	}
+}
@@ -0,0 +1,750 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dcarbone/go-tfc"
+	"github.com/rs/zerolog"
+)
+
+const (
+	registryPageSize = 100
+)
+
+type (
+	ProviderAttributes struct {
+		Name         string `json:"name"`
+		Namespace    string `json:"namespace"`
+		RegistryName string `json:"registry-name"`
+		CreatedAt    string `json:"created-at,omitempty"`
+		UpdatedAt    string `json:"updated-at,omitempty"`
+	}
+
+	ProviderData struct {
+		ID         string             `json:"id,omitempty"`
+		Type       string             `json:"type"`
+		Attributes ProviderAttributes `json:"attributes"`
+	}
+
+	ProviderResponse struct {
+		Data ProviderData `json:"data"`
+	}
+
+	CreateProviderRequest struct {
+		Data ProviderData `json:"data"`
+	}
+)
+
+func NewCreateProviderRequest(namespace, name, registryName string) CreateProviderRequest {
+	m := CreateProviderRequest{
+		Data: ProviderData{
+			Type: "registry-providers",
+			Attributes: ProviderAttributes{
+				Name:         name,
+				Namespace:    namespace,
+				RegistryName: registryName,
+			},
+		},
+	}
+	return m
+}
+
+type (
+	ProviderVersionLinks struct {
+		ShasumsUpload      string `json:"shasums-upload"`
+		ShasumsSigUpload   string `json:"shasums-sig-upload"`
+		ShasumsDownload    string `json:"shasums-download"`
+		ShasumsSigDownload string `json:"shasums-sig-download"`
+	}
+
+	ProviderVersionData struct {
+		ID         string                                          `json:"id"`
+		Type       string                                          `json:"type"`
+		Attributes tfc.CreateProviderVersionResponseDataAttributes `json:"attributes"`
+		Links      ProviderVersionLinks                            `json:"links"`
+	}
+
+	ProviderVersionResponse struct {
+		Data ProviderVersionData `json:"data"`
+	}
+)
+
+// mask registers the links as secrets, as anyone holding a pre-signed link may use it
+func (l ProviderVersionLinks) mask() {
+	maskSecret(l.ShasumsUpload, l.ShasumsSigUpload, l.ShasumsDownload, l.ShasumsSigDownload)
+}
+
+type (
+	ProviderPlatformLinks struct {
+		ProviderBinaryUpload   string `json:"provider-binary-upload"`
+		ProviderBinaryDownload string `json:"provider-binary-download"`
+	}
+
+	ProviderPlatformData struct {
+		ID         string                                                  `json:"id"`
+		Type       string                                                  `json:"type"`
+		Attributes tfc.CreateProviderVersionPlatformResponseDataAttributes `json:"attributes"`
+		Links      ProviderPlatformLinks                                   `json:"links"`
+	}
+
+	ProviderPlatformResponse struct {
+		Data ProviderPlatformData `json:"data"`
+	}
+
+	ProviderPlatformListResponse struct {
+		Data []ProviderPlatformData `json:"data"`
+		Meta ListMeta               `json:"meta"`
+	}
+)
+
+// ListMeta is the meta of a paginated list response
+type ListMeta struct {
+	Pagination struct {
+		NextPage *int `json:"next-page"`
+	} `json:"pagination"`
+}
+
+// nextPage returns the page following page, false once page is the last one
+func (m ListMeta) nextPage(page int) (int, bool) {
+	if next := m.Pagination.NextPage; next != nil && *next > page {
+		return *next, true
+	}
+	return 0, false
+}
+
+func (l ProviderPlatformLinks) mask() {
+	maskSecret(l.ProviderBinaryUpload, l.ProviderBinaryDownload)
+}
+
+type (
+	GPGKeyAttributes struct {
+		ASCIIArmor     string `json:"ascii-armor"`
+		CreatedAt      string `json:"created-at"`
+		KeyID          string `json:"key-id"`
+		Namespace      string `json:"namespace"`
+		Source         string `json:"source"`
+		SourceURL      string `json:"source-url"`
+		TrustSignature string `json:"trust-signature"`
+		UpdatedAt      string `json:"updated-at"`
+	}
+
+	GPGKeyData struct {
+		ID         string           `json:"id"`
+		Type       string           `json:"type"`
+		Attributes GPGKeyAttributes `json:"attributes"`
+	}
+
+	GPGKeyResponse struct {
+		Data GPGKeyData `json:"data"`
+	}
+
+	GPGKeyListResponse struct {
+		Data []GPGKeyData `json:"data"`
+		Meta ListMeta     `json:"meta"`
+	}
+
+	CreateGPGKeyRequestAttributes struct {
+		Namespace  string `json:"namespace"`
+		ASCIIArmor string `json:"ascii-armor"`
+	}
+
+	CreateGPGKeyRequestData struct {
+		Type       string                        `json:"type"`
+		Attributes CreateGPGKeyRequestAttributes `json:"attributes"`
+	}
+
+	CreateGPGKeyRequest struct {
+		Data CreateGPGKeyRequestData `json:"data"`
+	}
+)
+
+func NewCreateGPGKeyRequest(namespace, asciiArmor string) CreateGPGKeyRequest {
+	m := CreateGPGKeyRequest{
+		Data: CreateGPGKeyRequestData{
+			Type: "gpg-keys",
+			Attributes: CreateGPGKeyRequestAttributes{
+				Namespace:  namespace,
+				ASCIIArmor: asciiArmor,
+			},
+		},
+	}
+	return m
+}
+
+// FileUploadRequest describes a single artifact upload to a pre-signed registry upload link.  It mirrors
+// tfc.FileUploadRequest, adding the content length so uploads are not sent chunked.
+type FileUploadRequest struct {
+	File          io.Reader
+	ContentLength int64
+	Destination   string
+	ContentType   string
+	Filename      string
+}
+
+type (
+	ModuleVersionStatus struct {
+		Version string `json:"version"`
+		Status  string `json:"status"`
+		Error   string `json:"error"`
+	}
+
+	ModuleAttributes struct {
+		Name            string                `json:"name"`
+		Namespace       string                `json:"namespace"`
+		Provider        string                `json:"provider"`
+		RegistryName    string                `json:"registry-name"`
+		Status          string                `json:"status"`
+		VersionStatuses []ModuleVersionStatus `json:"version-statuses"`
+	}
+
+	ModuleData struct {
+		ID         string           `json:"id"`
+		Type       string           `json:"type"`
+		Attributes ModuleAttributes `json:"attributes"`
+	}
+
+	ModuleResponse struct {
+		Data ModuleData `json:"data"`
+	}
+)
+
+type serviceDiscoveryResponse struct {
+	TFEV2 string `json:"tfe.v2"`
+}
+
+// RegistryClient executes all Terraform Cloud / Enterprise api calls and artifact uploads made by this action.
+//
+// The vendored go-tfc client always constructs its own http client and uploads through a package-level one, so it
+// cannot honor custom CA bundles, client certificates or proxies.  Its request and response models are still used.
+type RegistryClient struct {
+	address  string
+	apiBase  string
+	gpgBase  string
+	token    string
+	hc       *http.Client
+	uploadHC *http.Client
+	log      zerolog.Logger
+
+	// retry applies to every request, limiter only to api requests as uploads are not subject to the api rate limit
+	retry   retryPolicy
+	limiter *tokenBucket
+
+	// readOnly prevents any request other than GET from being sent, see errReadOnly
+	readOnly bool
+}
+
+// errReadOnly is returned for any modifying request made with a read only client
+var errReadOnly = errors.New("registry client is read only")
+
+func NewRegistryClient(ctx context.Context, log zerolog.Logger, cfg *Config) (*RegistryClient, error) {
+	var err error
+
+	rc := RegistryClient{
+		token: cfg.TFToken,
+		log:   log,
+		retry: retryPolicy{
+			maxRetries: cfg.tfMaxRetries,
+			waitMin:    cfg.tfRetryWaitMin,
+			waitMax:    cfg.tfRetryWaitMax,
+		},
+		limiter: newTokenBucket(cfg.tfRateLimit),
+	}
+
+	if rc.hc, err = newTFHTTPClient(log, cfg, true); err != nil {
+		return nil, fmt.Errorf("error constructing api http client: %w", err)
+	}
+	if rc.uploadHC, err = newTFHTTPClient(log, cfg, false); err != nil {
+		return nil, fmt.Errorf("error constructing upload http client: %w", err)
+	}
+
+	addr := strings.TrimRight(cfg.TFAddress, "/")
+
+	// a bare hostname is resolved using terraform's remote service discovery protocol
+	if !strings.Contains(addr, "://") {
+		ctx, cancel := cfg.tfRequestContext(ctx)
+		defer cancel()
+		if rc.address, rc.apiBase, err = discoverTFEAPI(ctx, rc.hc, addr); err != nil {
+			return nil, err
+		}
+	} else {
+		rc.address = addr
+		rc.apiBase = fmt.Sprintf("%s/api/v2", addr)
+	}
+
+	rc.gpgBase = fmt.Sprintf("%s/api/registry/private/v2/gpg-keys", rc.address)
+
+	return &rc, nil
+}
+
+// discoverTFEAPI resolves the tfe.v2 api base of a host via /.well-known/terraform.json
+func discoverTFEAPI(ctx context.Context, hc *http.Client, hostname string) (string, string, error) {
+	address := fmt.Sprintf("https://%s", hostname)
+	discoveryURL := fmt.Sprintf("%s/.well-known/terraform.json", address)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, discoveryURL, nil)
+	if err != nil {
+		return "", "", fmt.Errorf("error constructing service discovery request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := hc.Do(req)
+	if err != nil {
+		return "", "", fmt.Errorf("error executing service discovery request %q: %w", discoveryURL, err)
+	}
+	defer drainReader(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("error executing service discovery request %q: %w", discoveryURL, newStatusError(resp, http.StatusOK))
+	}
+
+	services := serviceDiscoveryResponse{}
+	if err = json.NewDecoder(resp.Body).Decode(&services); err != nil {
+		return "", "", fmt.Errorf("error unmarshalling service discovery response: %w", err)
+	} else if services.TFEV2 == "" {
+		return "", "", fmt.Errorf("host %q does not advertise the \"tfe.v2\" service", hostname)
+	}
+
+	base, _ := url.Parse(discoveryURL)
+	ref, err := url.Parse(services.TFEV2)
+	if err != nil {
+		return "", "", fmt.Errorf("host %q advertised unparseable \"tfe.v2\" service url %q: %w", hostname, services.TFEV2, err)
+	}
+	apiURL := base.ResolveReference(ref)
+
+	return fmt.Sprintf("%s://%s", apiURL.Scheme, apiURL.Host), strings.TrimRight(apiURL.String(), "/"), nil
+}
+
+func (rc *RegistryClient) providerRoute(cfg *Config, parts ...string) string {
+	route := []string{
+		"organizations",
+		url.PathEscape(cfg.TFOrganizationName),
+		"registry-providers",
+		url.PathEscape(cfg.TFRegistryName),
+		url.PathEscape(cfg.TFNamespace),
+		url.PathEscape(cfg.TFProviderName),
+	}
+	for _, p := range parts {
+		route = append(route, url.PathEscape(p))
+	}
+	return path.Join(route...)
+}
+
+// apiURL returns the full url of a v2 api route
+func (rc *RegistryClient) apiURL(route string) string {
+	return fmt.Sprintf("%s/%s", rc.apiBase, route)
+}
+
+// do executes a single request against the v2 api.  See doAt.
+func (rc *RegistryClient) do(ctx context.Context, method, route string, query url.Values, body, out interface{}, expectedCode int) error {
+	return rc.doAt(ctx, rc.apiBase, method, route, query, body, out, expectedCode)
+}
+
+// doAt executes a single api request, decoding the response into out if provided.  Responses that do not carry the
+// expected code are returned as *tfc.StatusError so callers may inspect them with tfc.UnwrapStatusError.
+func (rc *RegistryClient) doAt(ctx context.Context, base, method, route string, query url.Values, body, out interface{}, expectedCode int) error {
+	var bodyRdr io.Reader
+
+	compiledURL := base
+	if route != "" {
+		compiledURL = fmt.Sprintf("%s/%s", base, route)
+	}
+	if len(query) > 0 {
+		compiledURL = fmt.Sprintf("%s?%s", compiledURL, query.Encode())
+	}
+
+	if rc.readOnly && method != http.MethodGet {
+		return fmt.Errorf("refusing to execute %s %q: %w", method, compiledURL, errReadOnly)
+	}
+
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("error marshalling body: %w", err)
+		}
+		bodyRdr = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, compiledURL, bodyRdr)
+	if err != nil {
+		return fmt.Errorf("error constructing request: %w", err)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", rc.token))
+	req.Header.Set("Accept", "application/vnd.api+json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/vnd.api+json")
+	}
+
+	resp, err := rc.send(ctx, rc.hc, req, true, expectedCode)
+	if err != nil {
+		return fmt.Errorf("error executing %s %q: %w", req.Method, req.URL, err)
+	}
+	defer drainReader(resp.Body)
+
+	if resp.StatusCode != expectedCode {
+		return fmt.Errorf("error executing %s %q: %w", req.Method, req.URL, newStatusError(resp, expectedCode))
+	}
+
+	if out != nil {
+		if err = json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("error unmarshalling response into %T: %w", out, err)
+		}
+	}
+
+	return nil
+}
+
+// GetProvider
+//
+// Executes: GET /api/v2/organizations/:organization_name/registry-providers/:registry_name/:namespace/:provider_name
+func (rc *RegistryClient) GetProvider(ctx context.Context, cfg *Config) (*ProviderResponse, error) {
+	out := ProviderResponse{}
+	if err := rc.do(ctx, http.MethodGet, rc.providerRoute(cfg), nil, nil, &out, http.StatusOK); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// CreateProvider
+//
+// Executes: POST /api/v2/organizations/:organization_name/registry-providers
+func (rc *RegistryClient) CreateProvider(ctx context.Context, cfg *Config, data CreateProviderRequest) (*ProviderResponse, error) {
+	route := path.Join("organizations", url.PathEscape(cfg.TFOrganizationName), "registry-providers")
+	out := ProviderResponse{}
+	if err := rc.do(ctx, http.MethodPost, route, nil, data, &out, http.StatusCreated); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// CreateProviderVersion
+//
+// Executes: POST /api/v2/organizations/:organization_name/registry-providers/:registry_name/:namespace/:provider_name/versions
+func (rc *RegistryClient) CreateProviderVersion(ctx context.Context, cfg *Config, data tfc.CreateProviderVersionRequest) (*ProviderVersionResponse, error) {
+	out := ProviderVersionResponse{}
+	if err := rc.do(ctx, http.MethodPost, rc.providerRoute(cfg, "versions"), nil, data, &out, http.StatusCreated); err != nil {
+		return nil, err
+	}
+	out.Data.Links.mask()
+	return &out, nil
+}
+
+// CreateProviderVersionPlatform
+//
+// Executes: POST /api/v2/organizations/:organization_name/registry-providers/:registry_name/:namespace/:provider_name/versions/:version/platforms
+func (rc *RegistryClient) CreateProviderVersionPlatform(ctx context.Context, cfg *Config, version string, data tfc.CreateProviderVersionPlatformRequest) (*ProviderPlatformResponse, error) {
+	out := ProviderPlatformResponse{}
+	if err := rc.do(ctx, http.MethodPost, rc.providerRoute(cfg, "versions", version, "platforms"), nil, data, &out, http.StatusCreated); err != nil {
+		return nil, err
+	}
+	out.Data.Links.mask()
+	return &out, nil
+}
+
+// GetProviderVersion
+//
+// Executes: GET /api/v2/organizations/:organization_name/registry-providers/:registry_name/:namespace/:provider_name/versions/:version
+func (rc *RegistryClient) GetProviderVersion(ctx context.Context, cfg *Config, version string) (*ProviderVersionResponse, error) {
+	out := ProviderVersionResponse{}
+	if err := rc.do(ctx, http.MethodGet, rc.providerRoute(cfg, "versions", version), nil, nil, &out, http.StatusOK); err != nil {
+		return nil, err
+	}
+	out.Data.Links.mask()
+	return &out, nil
+}
+
+// DeleteProviderVersion
+//
+// Executes: DELETE /api/v2/organizations/:organization_name/registry-providers/:registry_name/:namespace/:provider_name/versions/:version
+func (rc *RegistryClient) DeleteProviderVersion(ctx context.Context, cfg *Config, version string) error {
+	return rc.do(ctx, http.MethodDelete, rc.providerRoute(cfg, "versions", version), nil, nil, nil, http.StatusNoContent)
+}
+
+// DeleteProviderVersionPlatform
+//
+// Executes: DELETE /api/v2/organizations/:organization_name/registry-providers/:registry_name/:namespace/:provider_name/versions/:version/platforms/:os/:arch
+func (rc *RegistryClient) DeleteProviderVersionPlatform(ctx context.Context, cfg *Config, version, platformOS, platformArch string) error {
+	return rc.do(ctx, http.MethodDelete, rc.providerRoute(cfg, "versions", version, "platforms", platformOS, platformArch), nil, nil, nil, http.StatusNoContent)
+}
+
+// ListProviderVersionPlatforms
+//
+// Executes: GET /api/v2/organizations/:organization_name/registry-providers/:registry_name/:namespace/:provider_name/versions/:version/platforms
+func (rc *RegistryClient) ListProviderVersionPlatforms(ctx context.Context, cfg *Config, version string) ([]ProviderPlatformData, error) {
+	var platforms []ProviderPlatformData
+
+	query := url.Values{}
+	query.Set("page[size]", fmt.Sprintf("%d", registryPageSize))
+
+	// every page is fetched, a platform missing from the list would otherwise be created again
+	for page, more := 1, true; more; {
+		query.Set("page[number]", strconv.Itoa(page))
+
+		out := ProviderPlatformListResponse{}
+		if err := rc.do(ctx, http.MethodGet, rc.providerRoute(cfg, "versions", version, "platforms"), query, nil, &out, http.StatusOK); err != nil {
+			return nil, err
+		}
+		for _, p := range out.Data {
+			p.Links.mask()
+		}
+		platforms = append(platforms, out.Data...)
+		page, more = out.Meta.nextPage(page)
+	}
+
+	return platforms, nil
+}
+
+func (rc *RegistryClient) moduleRoute(cfg *Config, parts ...string) string {
+	route := []string{
+		"organizations",
+		url.PathEscape(cfg.TFOrganizationName),
+		"registry-modules",
+		url.PathEscape(cfg.TFRegistryName),
+		url.PathEscape(cfg.TFNamespace),
+		url.PathEscape(cfg.TFModuleName),
+		url.PathEscape(cfg.TFModuleProvider),
+	}
+	for _, p := range parts {
+		route = append(route, url.PathEscape(p))
+	}
+	return path.Join(route...)
+}
+
+// GetModule
+//
+// Executes: GET /api/v2/organizations/:organization_name/registry-modules/:registry_name/:namespace/:name/:provider
+func (rc *RegistryClient) GetModule(ctx context.Context, cfg *Config) (*ModuleResponse, error) {
+	out := ModuleResponse{}
+	if err := rc.do(ctx, http.MethodGet, rc.moduleRoute(cfg), nil, nil, &out, http.StatusOK); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// CreateModuleVersion
+//
+// Executes: POST /api/v2/organizations/:organization_name/registry-modules/:registry_name/:namespace/:name/:provider/versions
+func (rc *RegistryClient) CreateModuleVersion(ctx context.Context, cfg *Config, data tfc.CreateModuleVersionRequest) (*tfc.CreateModuleVersionResponse, error) {
+	out := tfc.CreateModuleVersionResponse{}
+	if err := rc.do(ctx, http.MethodPost, rc.moduleRoute(cfg, "versions"), nil, data, &out, http.StatusCreated); err != nil {
+		return nil, err
+	}
+	maskSecret(out.Data.Links.Upload)
+	return &out, nil
+}
+
+// GetGPGKey
+//
+// Executes: GET /api/registry/private/v2/gpg-keys/:namespace/:key_id
+func (rc *RegistryClient) GetGPGKey(ctx context.Context, namespace, keyID string) (*GPGKeyResponse, error) {
+	out := GPGKeyResponse{}
+	route := path.Join(url.PathEscape(namespace), url.PathEscape(keyID))
+	if err := rc.doAt(ctx, rc.gpgBase, http.MethodGet, route, nil, nil, &out, http.StatusOK); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ListGPGKeys
+//
+// Executes: GET /api/registry/private/v2/gpg-keys?filter[namespace]=:namespace
+func (rc *RegistryClient) ListGPGKeys(ctx context.Context, namespace string) ([]GPGKeyData, error) {
+	query := url.Values{}
+	query.Set("filter[namespace]", namespace)
+	query.Set("page[size]", fmt.Sprintf("%d", registryPageSize))
+
+	var keys []GPGKeyData
+	for page, more := 1, true; more; {
+		query.Set("page[number]", strconv.Itoa(page))
+
+		out := GPGKeyListResponse{}
+		if err := rc.doAt(ctx, rc.gpgBase, http.MethodGet, "", query, nil, &out, http.StatusOK); err != nil {
+			return nil, err
+		}
+		keys = append(keys, out.Data...)
+		page, more = out.Meta.nextPage(page)
+	}
+
+	return keys, nil
+}
+
+// CreateGPGKey
+//
+// Executes: POST /api/registry/private/v2/gpg-keys
+func (rc *RegistryClient) CreateGPGKey(ctx context.Context, data CreateGPGKeyRequest) (*GPGKeyResponse, error) {
+	out := GPGKeyResponse{}
+	if err := rc.doAt(ctx, rc.gpgBase, http.MethodPost, "", nil, data, &out, http.StatusCreated); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// DeleteGPGKey
+//
+// Executes: DELETE /api/registry/private/v2/gpg-keys/:namespace/:key_id
+func (rc *RegistryClient) DeleteGPGKey(ctx context.Context, namespace, keyID string) error {
+	route := path.Join(url.PathEscape(namespace), url.PathEscape(keyID))
+	err := rc.doAt(ctx, rc.gpgBase, http.MethodDelete, route, nil, nil, nil, http.StatusNoContent)
+	// the api documents 201 as the success code of this call, accept either.
+	if se := tfc.UnwrapStatusError(err); se != nil && se.ActualCode == http.StatusCreated {
+		return nil
+	}
+	return err
+}
+
+// DownloadArtifact fetches the full contents of a previously uploaded artifact, such as a shasums file.
+func (rc *RegistryClient) DownloadArtifact(ctx context.Context, link string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error constructing request: %w", err)
+	}
+
+	resp, err := rc.send(ctx, rc.uploadHC, req, false, http.StatusOK)
+	if err != nil {
+		return nil, fmt.Errorf("error downloading artifact: %w", err)
+	}
+	defer drainReader(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error downloading artifact: %w", newStatusError(resp, http.StatusOK))
+	}
+
+	return ioutil.ReadAll(resp.Body)
+}
+
+// UploadArtifact uploads a single artifact to a pre-signed upload link returned by the registry
+func (rc *RegistryClient) UploadArtifact(ctx context.Context, data FileUploadRequest) error {
+	if rc.readOnly {
+		return fmt.Errorf("refusing to upload %q: %w", data.Filename, errReadOnly)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, data.Destination, data.File)
+	if err != nil {
+		return fmt.Errorf("error constructing request: %w", err)
+	}
+	if data.ContentLength > 0 {
+		req.ContentLength = data.ContentLength
+	}
+	req.Header.Set("Content-Type", data.ContentType)
+	req.Header.Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", data.Filename))
+
+	// a seekable file may be sent again, a stream only once.  the transport closes the body after every attempt, so
+	// the file itself is left for the caller to close.
+	if s, ok := data.File.(io.ReadSeeker); ok && req.GetBody == nil {
+		start, err := s.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return fmt.Errorf("error seeking %q: %w", data.Filename, err)
+		}
+		req.Body = io.NopCloser(s)
+		req.GetBody = func() (io.ReadCloser, error) {
+			if _, err := s.Seek(start, io.SeekStart); err != nil {
+				return nil, err
+			}
+			return io.NopCloser(s), nil
+		}
+	}
+
+	resp, err := rc.send(ctx, rc.uploadHC, req, false, http.StatusOK)
+	if err != nil {
+		return fmt.Errorf("error executing %s upload: %w", req.Method, err)
+	}
+	defer drainReader(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("error executing %s upload: %w", req.Method, newStatusError(resp, http.StatusOK))
+	}
+
+	return nil
+}
+
+// send executes req, retrying as allowed by the retry policy until expectedCode is returned.  Api requests wait on the
+// rate limiter before every attempt.  The last response or error is returned once retries are exhausted, or when the
+// next wait would not end before the deadline of ctx.
+func (rc *RegistryClient) send(ctx context.Context, hc *http.Client, req *http.Request, api bool, expectedCode int) (*http.Response, error) {
+	for retry := 0; ; retry++ {
+		if retry > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, fmt.Errorf("error rewinding request body: %w", err)
+			}
+			req.Body = body
+		}
+
+		if api {
+			if err := rc.limiter.wait(ctx); err != nil {
+				return nil, err
+			}
+		}
+
+		resp, err := hc.Do(req)
+		if err == nil && resp.StatusCode == expectedCode {
+			return resp, nil
+		}
+
+		if retry >= rc.retry.maxRetries || !retryable(ctx, req, resp, err) {
+			return resp, err
+		}
+
+		wait := rc.retry.backoff(retry+1, resp)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			return resp, err
+		}
+
+		cause := err
+		if cause == nil {
+			cause = fmt.Errorf("response code %d", resp.StatusCode)
+			drainReader(resp.Body)
+		}
+
+		log := groupedLog(ctx, rc.log)
+		log.Warn().
+			Err(cause).
+			Str("method", req.Method).
+			Str("host", req.URL.Host).
+			Dur("wait", wait).
+			Msgf("Request failed, retrying (%d of %d)...", retry+1, rc.retry.maxRetries)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			if err == nil {
+				return nil, ctx.Err()
+			}
+			return nil, err
+		case <-timer.C:
+		}
+	}
+}
+
+func newStatusError(resp *http.Response, expected int) error {
+	var buf bytes.Buffer
+	_, _ = io.Copy(&buf, resp.Body)
+
+	// don't particularly care if this fails, the raw body is kept regardless.
+	apiErr := new(tfc.APIError)
+	_ = json.Unmarshal(buf.Bytes(), apiErr)
+
+	return &tfc.StatusError{
+		ExpectedCode: expected,
+		ActualCode:   resp.StatusCode,
+		Body:         strings.TrimSpace(buf.String()),
+		CloudError:   *apiErr,
+	}
+}
+
+func isNotFound(err error) bool {
+	var se *tfc.StatusError
+	return errors.As(err, &se) && se.ActualCode == http.StatusNotFound
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestListProviderVersionPlatformsPagination(t *testing.T) {
+	const total = 2*registryPageSize + 5
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		size, _ := strconv.Atoi(r.URL.Query().Get("page[size]"))
+		page, _ := strconv.Atoi(r.URL.Query().Get("page[number]"))
+		if size != registryPageSize || page < 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		out := ProviderPlatformListResponse{}
+		for i := (page - 1) * size; i < min(page*size, total); i++ {
+			out.Data = append(out.Data, ProviderPlatformData{ID: fmt.Sprintf("provpltfrm-%d", i)})
+		}
+		if page*size < total {
+			next := page + 1
+			out.Meta.Pagination.NextPage = &next
+		}
+		_ = json.NewEncoder(w).Encode(out)
+	}))
+	defer srv.Close()
+
+	rc := RegistryClient{
+		log:     zerolog.Nop(),
+		hc:      srv.Client(),
+		apiBase: srv.URL,
+	}
+	cfg := Config{TFOrganizationName: "org", TFRegistryName: "private", TFNamespace: "org", TFProviderName: "foo"}
+
+	platforms, err := rc.ListProviderVersionPlatforms(context.Background(), &cfg, "1.2.3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(platforms) != total {
+		t.Fatalf("listed %d platforms, expected %d", len(platforms), total)
+	}
+	for i, p := range platforms {
+		if expected := fmt.Sprintf("provpltfrm-%d", i); p.ID != expected {
+			t.Fatalf("platform %d has id %q, expected %q", i, p.ID, expected)
+		}
+	}
+}
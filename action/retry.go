@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// retryPolicy decides whether, and after how long, a failed registry request is sent again
+type retryPolicy struct {
+	maxRetries int
+	waitMin    time.Duration
+	waitMax    time.Duration
+}
+
+// retryable returns true if the request may safely be sent again after resp or err.  Idempotent methods are retried
+// after connection errors and transient server errors.  Anything else, such as POST, is only retried on 429, as the
+// request was refused before being processed.
+func retryable(ctx context.Context, req *http.Request, resp *http.Response, err error) bool {
+	// a cancelled or timed out request is not the server's fault
+	if ctx.Err() != nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	// a streamed body has already been consumed
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+
+	if resp != nil && resp.StatusCode == http.StatusTooManyRequests {
+		return true
+	}
+
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+	default:
+		return false
+	}
+
+	if err != nil {
+		return true
+	}
+
+	switch resp.StatusCode {
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// backoff returns the wait before the given retry, starting at 1.  Retry-After is honored when the response has one,
+// otherwise the wait doubles from waitMin up to waitMax with full jitter, so concurrent uploads do not retry in step.
+func (p retryPolicy) backoff(retry int, resp *http.Response) time.Duration {
+	if wait, ok := retryAfter(resp); ok {
+		return wait
+	}
+
+	ceiling := float64(p.waitMin) * math.Pow(2, float64(retry-1))
+	if ceiling > float64(p.waitMax) {
+		ceiling = float64(p.waitMax)
+	}
+
+	return p.waitMin/2 + time.Duration(rand.Int63n(int64(ceiling)-int64(p.waitMin/2)+1))
+}
+
+// retryAfter parses the Retry-After header of resp, either as a number of seconds or as an http date
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	if resp == nil {
+		return 0, false
+	}
+
+	v := resp.Header.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+
+	if t, err := http.ParseTime(v); err == nil {
+		if wait := time.Until(t); wait > 0 {
+			return wait, true
+		}
+		return 0, true
+	}
+
+	return 0, false
+}
+
+// tokenBucket limits the rate of api requests made by a registry client.  It is shared by every goroutine using the
+// client, so a large platform matrix stays under the api rate limit.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket returns a limiter allowing rate requests per second, with bursts of up to one second's worth.  A
+// rate of zero disables limiting.
+func newTokenBucket(rate float64) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	burst := math.Max(1, math.Ceil(rate))
+	tb := tokenBucket{
+		rate:   rate,
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+	return &tb
+}
+
+// wait blocks until a request may be made, or ctx is done
+func (tb *tokenBucket) wait(ctx context.Context) error {
+	if tb == nil {
+		return nil
+	}
+
+	for {
+		tb.mu.Lock()
+		now := time.Now()
+		tb.tokens = math.Min(tb.burst, tb.tokens+now.Sub(tb.last).Seconds()*tb.rate)
+		tb.last = now
+		if tb.tokens >= 1 {
+			tb.tokens--
+			tb.mu.Unlock()
+			return nil
+		}
+		wait := time.Duration((1 - tb.tokens) / tb.rate * float64(time.Second))
+		tb.mu.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func TestRetryable(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var (
+		errConn    = errors.New("connection reset by peer")
+		rewindable = func(r *http.Request) {
+			r.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(strings.NewReader("x")), nil }
+		}
+		streamBody = io.NopCloser(strings.NewReader("x"))
+		status     = func(code int) *http.Response { return &http.Response{StatusCode: code, Header: http.Header{}} }
+		newRequest = func(method string, body io.ReadCloser, opts ...func(*http.Request)) *http.Request {
+			req, _ := http.NewRequest(method, "https://archivist.example.com/v1/object/abc", nil)
+			if body != nil {
+				req.Body = body
+			}
+			for _, opt := range opts {
+				opt(req)
+			}
+			return req
+		}
+	)
+
+	tests := []struct {
+		name      string
+		ctx       context.Context
+		req       *http.Request
+		resp      *http.Response
+		err       error
+		retryable bool
+	}{
+		{name: "get-connection-error", req: newRequest(http.MethodGet, nil), err: errConn, retryable: true},
+		{name: "get-502", req: newRequest(http.MethodGet, nil), resp: status(http.StatusBadGateway), retryable: true},
+		{name: "get-503", req: newRequest(http.MethodGet, nil), resp: status(http.StatusServiceUnavailable), retryable: true},
+		{name: "get-404", req: newRequest(http.MethodGet, nil), resp: status(http.StatusNotFound)},
+		{name: "get-501", req: newRequest(http.MethodGet, nil), resp: status(http.StatusNotImplemented)},
+		{name: "delete-500", req: newRequest(http.MethodDelete, nil), resp: status(http.StatusInternalServerError), retryable: true},
+		{name: "post-502", req: newRequest(http.MethodPost, nil), resp: status(http.StatusBadGateway)},
+		{name: "post-connection-error", req: newRequest(http.MethodPost, nil), err: errConn},
+		{name: "post-429", req: newRequest(http.MethodPost, nil), resp: status(http.StatusTooManyRequests), retryable: true},
+		{name: "put-stream-502", req: newRequest(http.MethodPut, streamBody), resp: status(http.StatusBadGateway)},
+		{name: "put-stream-connection-error", req: newRequest(http.MethodPut, streamBody), err: errConn},
+		{name: "put-stream-429", req: newRequest(http.MethodPut, streamBody), resp: status(http.StatusTooManyRequests)},
+		{name: "put-rewindable-502", req: newRequest(http.MethodPut, streamBody, rewindable), resp: status(http.StatusBadGateway), retryable: true},
+		{name: "put-rewindable-connection-error", req: newRequest(http.MethodPut, streamBody, rewindable), err: errConn, retryable: true},
+		{name: "context-cancelled", ctx: cancelled, req: newRequest(http.MethodGet, nil), err: context.Canceled},
+		{name: "deadline-exceeded", req: newRequest(http.MethodGet, nil), err: context.DeadlineExceeded},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := tt.ctx
+			if ctx == nil {
+				ctx = context.Background()
+			}
+			if got := retryable(ctx, tt.req, tt.resp, tt.err); got != tt.retryable {
+				t.Fatalf("retryable() = %t, expected %t", got, tt.retryable)
+			}
+		})
+	}
+}
+
+func TestUploadArtifactRetry(t *testing.T) {
+	const body = "terraform-provider-foo_1.2.3_linux_amd64.zip contents"
+
+	var attempts atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		// the first attempt fails, any retry must send the body in full
+		if attempts.Add(1) == 1 || string(b) != body {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	fpath := filepath.Join(t.TempDir(), "binary.zip")
+	if err := os.WriteFile(fpath, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	rc := RegistryClient{
+		log:      zerolog.Nop(),
+		uploadHC: srv.Client(),
+		retry:    retryPolicy{maxRetries: 2, waitMin: time.Millisecond, waitMax: time.Millisecond},
+	}
+
+	tests := []struct {
+		name     string
+		file     func() io.Reader
+		attempts int32
+		err      bool
+	}{
+		{
+			name: "file",
+			file: func() io.Reader {
+				f, err := os.Open(fpath)
+				if err != nil {
+					t.Fatal(err)
+				}
+				t.Cleanup(func() { _ = f.Close() })
+				return f
+			},
+			attempts: 2,
+		},
+		{
+			name: "stream",
+			// hides the Seek of the underlying reader
+			file:     func() io.Reader { return io.MultiReader(strings.NewReader(body)) },
+			attempts: 1,
+			err:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts.Store(0)
+			err := rc.UploadArtifact(context.Background(), FileUploadRequest{
+				File:          tt.file(),
+				ContentLength: int64(len(body)),
+				Destination:   srv.URL + "/v1/object/abc",
+				ContentType:   "binary/octet-stream",
+				Filename:      "binary.zip",
+			})
+			if (err != nil) != tt.err {
+				t.Fatalf("UploadArtifact() error = %v, expected error: %t", err, tt.err)
+			}
+			if got := attempts.Load(); got != tt.attempts {
+				t.Fatalf("made %d attempts, expected %d", got, tt.attempts)
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	p := retryPolicy{maxRetries: 4, waitMin: time.Second, waitMax: 8 * time.Second}
+
+	withRetryAfter := func(v string) *http.Response {
+		resp := http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{}}
+		resp.Header.Set("Retry-After", v)
+		return &resp
+	}
+
+	t.Run("retry-after-seconds", func(t *testing.T) {
+		// honored even beyond waitMax, the server knows best
+		if got := p.backoff(1, withRetryAfter("20")); got != 20*time.Second {
+			t.Fatalf("backoff() = %s, expected 20s", got)
+		}
+		if got := p.backoff(3, withRetryAfter("0")); got != 0 {
+			t.Fatalf("backoff() = %s, expected 0s", got)
+		}
+	})
+
+	t.Run("retry-after-date", func(t *testing.T) {
+		at := time.Now().Add(5 * time.Second).UTC().Format(http.TimeFormat)
+		if got := p.backoff(1, withRetryAfter(at)); got <= 3*time.Second || got > 5*time.Second {
+			t.Fatalf("backoff() = %s, expected about 5s", got)
+		}
+		past := time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat)
+		if got := p.backoff(1, withRetryAfter(past)); got != 0 {
+			t.Fatalf("backoff() = %s, expected 0s", got)
+		}
+	})
+
+	t.Run("exponential", func(t *testing.T) {
+		for retry, ceiling := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 8 * time.Second, 10: 8 * time.Second} {
+			for i := 0; i < 100; i++ {
+				// an invalid Retry-After is ignored
+				got := p.backoff(retry, withRetryAfter("soon"))
+				if got < p.waitMin/2 || got > ceiling {
+					t.Fatalf("backoff(%d) = %s, expected between %s and %s", retry, got, p.waitMin/2, ceiling)
+				}
+			}
+		}
+	})
+}
+
+func TestTokenBucket(t *testing.T) {
+	if newTokenBucket(0) != nil {
+		t.Fatal("expected a rate of zero to disable limiting")
+	}
+
+	tb := newTokenBucket(10)
+	start := time.Now()
+	// the burst of 10 is free, the next 5 take half a second
+	for i := 0; i < 15; i++ {
+		if err := tb.wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Fatalf("15 requests at 10/s took %s, expected about 500ms", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := newTokenBucket(0.001).wait(ctx); err != nil {
+		// the initial token is available right away
+		t.Fatal(err)
+	}
+	slow := newTokenBucket(0.001)
+	_ = slow.wait(context.Background())
+	if err := slow.wait(ctx); err == nil {
+		t.Fatal("expected a cancelled context to stop waiting")
+	}
+}
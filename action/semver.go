@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SemVer is a version as defined by https://semver.org/spec/v2.0.0.html
+type SemVer struct {
+	Major      uint64
+	Minor      uint64
+	Patch      uint64
+	Prerelease []string
+	Build      []string
+}
+
+// ParseSemVer parses a SemVer 2.0 version string.  A leading "v" is not part of the spec and must be trimmed by the
+// caller.
+func ParseSemVer(s string) (SemVer, error) {
+	var (
+		v   SemVer
+		err error
+	)
+
+	rest := s
+	if i := strings.IndexByte(rest, '+'); i >= 0 {
+		if v.Build, err = parseSemVerIdentifiers(rest[i+1:], "build metadata", false); err != nil {
+			return SemVer{}, fmt.Errorf("invalid semantic version %q: %w", s, err)
+		}
+		rest = rest[:i]
+	}
+	if i := strings.IndexByte(rest, '-'); i >= 0 {
+		if v.Prerelease, err = parseSemVerIdentifiers(rest[i+1:], "pre-release", true); err != nil {
+			return SemVer{}, fmt.Errorf("invalid semantic version %q: %w", s, err)
+		}
+		rest = rest[:i]
+	}
+
+	core := strings.Split(rest, ".")
+	if len(core) != 3 {
+		return SemVer{}, fmt.Errorf("invalid semantic version %q: expected MAJOR.MINOR.PATCH", s)
+	}
+
+	for i, dst := range []*uint64{&v.Major, &v.Minor, &v.Patch} {
+		name := [...]string{"major", "minor", "patch"}[i]
+		if !isSemVerNumeric(core[i]) {
+			return SemVer{}, fmt.Errorf("invalid semantic version %q: %s version %q must be a number without leading zeros", s, name, core[i])
+		}
+		if *dst, err = strconv.ParseUint(core[i], 10, 64); err != nil {
+			return SemVer{}, fmt.Errorf("invalid semantic version %q: %s version %q is out of range", s, name, core[i])
+		}
+	}
+
+	return v, nil
+}
+
+// IsPrerelease returns true if the version has pre-release identifiers, such as "1.4.0-beta.2"
+func (v SemVer) IsPrerelease() bool {
+	return len(v.Prerelease) > 0
+}
+
+func (v SemVer) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if len(v.Prerelease) > 0 {
+		s += "-" + strings.Join(v.Prerelease, ".")
+	}
+	if len(v.Build) > 0 {
+		s += "+" + strings.Join(v.Build, ".")
+	}
+	return s
+}
+
+func parseSemVerIdentifiers(s, kind string, noLeadingZeros bool) ([]string, error) {
+	ids := strings.Split(s, ".")
+	for _, id := range ids {
+		if id == "" {
+			return nil, fmt.Errorf("%s identifiers must not be empty", kind)
+		}
+		for _, r := range id {
+			if !(r >= '0' && r <= '9') && !(r >= 'a' && r <= 'z') && !(r >= 'A' && r <= 'Z') && r != '-' {
+				return nil, fmt.Errorf("%s identifier %q may only contain [0-9A-Za-z-]", kind, id)
+			}
+		}
+		if noLeadingZeros && isSemVerDigits(id) && !isSemVerNumeric(id) {
+			return nil, fmt.Errorf("numeric %s identifier %q must not have leading zeros", kind, id)
+		}
+	}
+	return ids, nil
+}
+
+func isSemVerDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func isSemVerNumeric(s string) bool {
+	return isSemVerDigits(s) && (s == "0" || s[0] != '0')
+}
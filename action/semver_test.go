@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseSemVer(t *testing.T) {
+	tests := []struct {
+		in      string
+		version string
+		pre     bool
+		err     bool
+	}{
+		{in: "1.2.3", version: "1.2.3"},
+		{in: "0.0.0", version: "0.0.0"},
+		{in: "1.4.0-beta.2", version: "1.4.0-beta.2", pre: true},
+		{in: "1.4.0-0.3.7", version: "1.4.0-0.3.7", pre: true},
+		{in: "1.4.0-x-y.7", version: "1.4.0-x-y.7", pre: true},
+		{in: "1.0.0+20130313144700", version: "1.0.0+20130313144700"},
+		{in: "1.0.0-rc.1+build.001", version: "1.0.0-rc.1+build.001", pre: true},
+		{in: "1.0.0+exp.sha-5114f85", version: "1.0.0+exp.sha-5114f85"},
+		{in: "1.0.0-alpha+001", version: "1.0.0-alpha+001", pre: true},
+		{in: "1.2", err: true},
+		{in: "1.2.3.4", err: true},
+		{in: "v1.2.3", err: true},
+		{in: "01.2.3", err: true},
+		{in: "1.02.3", err: true},
+		{in: "1.2.-3", err: true},
+		{in: "1.2.3-", err: true},
+		{in: "1.2.3-01", err: true},
+		{in: "1.2.3-beta..1", err: true},
+		{in: "1.2.3-beta_1", err: true},
+		{in: "1.2.3+", err: true},
+		{in: "1.2.3+build..1", err: true},
+		{in: "99999999999999999999.0.0", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			v, err := ParseSemVer(tt.in)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("expected error, got %q", v)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if v.String() != tt.version {
+				t.Errorf("String() = %q, expected %q", v.String(), tt.version)
+			}
+			if v.IsPrerelease() != tt.pre {
+				t.Errorf("IsPrerelease() = %t, expected %t", v.IsPrerelease(), tt.pre)
+			}
+		})
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/google/go-github/v47/github"
+	"github.com/hashicorp/go-cleanhttp"
+	"github.com/rs/zerolog"
+)
+
+// ReleaseAsset describes a single file belonging to a release, independent of where the release is sourced from.
+type ReleaseAsset struct {
+	// ID is the github release asset id.  Always 0 for local files.
+	ID   int64
+	Name string
+	Size int64
+}
+
+func (a ReleaseAsset) MarshalZerologObject(ev *zerolog.Event) {
+	ev.Int64("id", a.ID)
+	ev.Str("name", a.Name)
+	ev.Int64("size", a.Size)
+}
+
+// ReleaseSource provides the list of assets making up a release and access to their contents
+type ReleaseSource interface {
+	// Assets returns the list of all assets in the release
+	Assets(ctx context.Context, log zerolog.Logger) ([]ReleaseAsset, error)
+
+	// Open returns a reader of the full contents of the provided asset.  The caller must close it.
+	Open(ctx context.Context, asset ReleaseAsset) (io.ReadCloser, error)
+}
+
+// rangeReleaseSource is implemented by sources able to resume a download part way through
+type rangeReleaseSource interface {
+	// OpenAt returns a reader of the contents of the provided asset from offset on.  The caller must close it.
+	OpenAt(ctx context.Context, asset ReleaseAsset, offset int64) (io.ReadCloser, error)
+}
+
+// localReleaseSource is implemented by sources whose assets are already files on disk
+type localReleaseSource interface {
+	// Path returns the location of the provided asset
+	Path(asset ReleaseAsset) string
+}
+
+// NewReleaseSource constructs the appropriate ReleaseSource for the provided config
+func NewReleaseSource(log zerolog.Logger, cfg *Config) (ReleaseSource, error) {
+	if cfg.DistDir != "" {
+		return newDistReleaseSource(cfg)
+	}
+
+	ghc, err := NewGithubClient(log, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error constructing github.Client: %w", err)
+	}
+
+	return &githubReleaseSource{ghc: ghc, cfg: cfg, log: log}, nil
+}
+
+// githubReleaseSource reads assets from the github release being published, by id when known or else by tag
+type githubReleaseSource struct {
+	ghc *github.Client
+	cfg *Config
+	log zerolog.Logger
+}
+
+func (s *githubReleaseSource) Assets(ctx context.Context, log zerolog.Logger) ([]ReleaseAsset, error) {
+	var (
+		releaseMeta *github.RepositoryRelease
+		err         error
+	)
+
+	// draft releases can only be fetched by id
+	if s.cfg.release.ID != 0 {
+		releaseMeta, _, err = s.ghc.Repositories.GetRelease(ctx, s.cfg.GithubRepositoryOwner, s.cfg.githubRepository(), s.cfg.release.ID)
+	} else {
+		releaseMeta, _, err = s.ghc.Repositories.GetReleaseByTag(ctx, s.cfg.GithubRepositoryOwner, s.cfg.githubRepository(), s.cfg.releaseTag())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error fetching release metadata from github: %w", err)
+	}
+
+	if tag := releaseMeta.GetTagName(); tag != s.cfg.releaseTag() {
+		return nil, fmt.Errorf("release %d has tag %q, expected %q", releaseMeta.GetID(), tag, s.cfg.releaseTag())
+	}
+
+	assets := make([]ReleaseAsset, 0)
+
+	for _, asset := range releaseMeta.Assets {
+		if asset.Name == nil || asset.URL == nil || asset.ID == nil {
+			log.Debug().
+				Interface("id", asset.ID).
+				Interface("name", asset.Name).
+				Interface("url", asset.URL).
+				Msg("Skipping asset as at least one of name, url, and id are empty")
+			continue
+		}
+		assets = append(assets, ReleaseAsset{
+			ID:   asset.GetID(),
+			Name: asset.GetName(),
+			Size: int64(asset.GetSize()),
+		})
+	}
+
+	return assets, nil
+}
+
+func (s *githubReleaseSource) Open(ctx context.Context, asset ReleaseAsset) (io.ReadCloser, error) {
+	rdr, _, err := s.ghc.Repositories.DownloadReleaseAsset(ctx, s.cfg.GithubRepositoryOwner, s.cfg.githubRepository(), asset.ID, withHTTPLog(s.log, s.cfg, cleanhttp.DefaultClient()))
+	if err != nil {
+		if rdr != nil {
+			drainReader(rdr)
+		}
+		return nil, err
+	}
+	return rdr, nil
+}
+
+// OpenAt requests the asset from its download location with a Range header.  When the asset is served without
+// support for it, the response is read from the start and skipped ahead.
+func (s *githubReleaseSource) OpenAt(ctx context.Context, asset ReleaseAsset, offset int64) (io.ReadCloser, error) {
+	if offset == 0 {
+		return s.Open(ctx, asset)
+	}
+
+	rdr, location, err := s.ghc.Repositories.DownloadReleaseAsset(ctx, s.cfg.GithubRepositoryOwner, s.cfg.githubRepository(), asset.ID, nil)
+	if err != nil {
+		if rdr != nil {
+			drainReader(rdr)
+		}
+		return nil, err
+	}
+
+	if rdr == nil {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+		if err != nil {
+			return nil, fmt.Errorf("error constructing request: %w", err)
+		}
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+
+		resp, err := withHTTPLog(s.log, s.cfg, cleanhttp.DefaultClient()).Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("error resuming download: %w", err)
+		}
+
+		switch resp.StatusCode {
+		case http.StatusPartialContent:
+			return resp.Body, nil
+		case http.StatusOK:
+			rdr = resp.Body
+		default:
+			drainReader(resp.Body)
+			return nil, fmt.Errorf("error resuming download: expected response code %d, saw %d", http.StatusPartialContent, resp.StatusCode)
+		}
+	}
+
+	if _, err = io.CopyN(io.Discard, rdr, offset); err != nil {
+		drainReader(rdr)
+		return nil, fmt.Errorf("error skipping to offset %d: %w", offset, err)
+	}
+
+	return rdr, nil
+}
+
+// distReleaseSource reads assets from a local directory, such as the dist/ directory produced by goreleaser
+type distReleaseSource struct {
+	dir string
+}
+
+func newDistReleaseSource(cfg *Config) (*distReleaseSource, error) {
+	if fi, err := os.Stat(cfg.DistDir); err != nil {
+		return nil, fmt.Errorf("error reading dist directory: %w", err)
+	} else if !fi.IsDir() {
+		return nil, fmt.Errorf("dist directory %q is not a directory", cfg.DistDir)
+	}
+	return &distReleaseSource{dir: cfg.DistDir}, nil
+}
+
+func (s *distReleaseSource) Assets(_ context.Context, log zerolog.Logger) ([]ReleaseAsset, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("error listing dist directory %q: %w", s.dir, err)
+	}
+
+	assets := make([]ReleaseAsset, 0)
+
+	for _, entry := range entries {
+		// goreleaser places a number of build directories next to the release artifacts
+		if !entry.Type().IsRegular() {
+			log.Debug().Str("name", entry.Name()).Msg("Skipping dist directory entry as it is not a regular file")
+			continue
+		}
+		fi, err := entry.Info()
+		if err != nil {
+			return nil, fmt.Errorf("error reading dist file %q: %w", entry.Name(), err)
+		}
+		assets = append(assets, ReleaseAsset{
+			Name: fi.Name(),
+			Size: fi.Size(),
+		})
+	}
+
+	return assets, nil
+}
+
+func (s *distReleaseSource) Open(_ context.Context, asset ReleaseAsset) (io.ReadCloser, error) {
+	return os.Open(s.Path(asset))
+}
+
+func (s *distReleaseSource) Path(asset ReleaseAsset) string {
+	return filepath.Join(s.dir, asset.Name)
+}
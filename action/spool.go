@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// assetSpool keeps downloaded release assets on disk, so an upload may be retried, and several targets served, from a
+// single download.  With TF_SPOOL_DIR set the files are kept as a cache.  They are named after the asset id and
+// shasum, so a later run publishing the same release reuses them or resumes a partial download.
+type assetSpool struct {
+	dir   string
+	keep  bool
+	retry retryPolicy
+}
+
+func newAssetSpool(cfg *Config) (*assetSpool, error) {
+	sp := assetSpool{
+		retry: retryPolicy{
+			maxRetries: cfg.tfMaxRetries,
+			waitMin:    cfg.tfRetryWaitMin,
+			waitMax:    cfg.tfRetryWaitMax,
+		},
+	}
+
+	if cfg.TFSpoolDir != "" {
+		if err := os.MkdirAll(cfg.TFSpoolDir, 0o700); err != nil {
+			return nil, fmt.Errorf("error creating spool directory: %w", err)
+		}
+		sp.dir, sp.keep = cfg.TFSpoolDir, true
+		return &sp, nil
+	}
+
+	var err error
+	if sp.dir, err = os.MkdirTemp("", "tfcloud-provider-push-"); err != nil {
+		return nil, fmt.Errorf("error creating temporary spool directory: %w", err)
+	}
+
+	return &sp, nil
+}
+
+// close removes the spool directory, unless it is kept as a cache
+func (sp *assetSpool) close() {
+	if !sp.keep {
+		_ = os.RemoveAll(sp.dir)
+	}
+}
+
+// fetch returns the path of a local copy of a provider artifact, verified against its shasum, see fetchAsset
+func (sp *assetSpool) fetch(ctx context.Context, log zerolog.Logger, src ReleaseSource, pa ProviderArtifact) (string, func(), error) {
+	return sp.fetchAsset(ctx, log, src, pa.Asset, pa.ShasumFileEntry.Shasum)
+}
+
+// fetchAsset returns the path of a local copy of the asset, verified against its size and shasum, downloading it if
+// needed.  An asset without a known shasum, such as a module tarball, is verified against its size only.  Assets of a
+// local source are used in place.  The returned func must be called once the file is no longer used.
+func (sp *assetSpool) fetchAsset(ctx context.Context, log zerolog.Logger, src ReleaseSource, asset ReleaseAsset, shasum string) (string, func(), error) {
+	if ls, ok := src.(localReleaseSource); ok {
+		fpath := ls.Path(asset)
+		if err := verifyFileShasum(fpath, shasum, asset.Size); err != nil {
+			return "", nil, fmt.Errorf("error verifying release asset %q: %w", asset.Name, err)
+		}
+		return fpath, func() {}, nil
+	}
+
+	name := fmt.Sprintf("%d", asset.ID)
+	if shasum != "" {
+		name = fmt.Sprintf("%d-%s", asset.ID, strings.ToLower(shasum))
+	}
+	fpath := filepath.Join(sp.dir, name)
+	done := func() {
+		if !sp.keep {
+			_ = os.Remove(fpath)
+		}
+	}
+
+	if _, err := os.Stat(fpath); err == nil {
+		if err = verifyFileShasum(fpath, shasum, asset.Size); err == nil {
+			log.Info().Msg("Using previously downloaded release asset")
+			return fpath, done, nil
+		}
+		log.Warn().Err(err).Msg("Discarding previously downloaded release asset")
+		_ = os.Remove(fpath)
+	}
+
+	// the download only takes the final name once complete and verified
+	part := fpath + ".part"
+
+	if err := sp.download(ctx, log, src, asset, part); err != nil {
+		return "", nil, err
+	}
+
+	if err := verifyFileShasum(part, shasum, asset.Size); err != nil {
+		_ = os.Remove(part)
+		return "", nil, fmt.Errorf("error verifying release asset %q: %w", asset.Name, err)
+	}
+
+	if err := os.Rename(part, fpath); err != nil {
+		_ = os.Remove(part)
+		return "", nil, fmt.Errorf("error moving release asset %q into spool: %w", asset.Name, err)
+	}
+
+	return fpath, done, nil
+}
+
+// download writes the asset to fpath, resuming from the data already there when the source supports it.  A dropped
+// download is resumed, or restarted, up to the configured number of retries.
+func (sp *assetSpool) download(ctx context.Context, log zerolog.Logger, src ReleaseSource, asset ReleaseAsset, fpath string) error {
+	f, err := os.OpenFile(fpath, os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("error opening spool file for release asset %q: %w", asset.Name, err)
+	}
+	defer func() { _ = f.Close() }()
+
+	rs, resumable := src.(rangeReleaseSource)
+
+	for retry := 0; ; retry++ {
+		var offset int64
+		if offset, err = f.Seek(0, io.SeekEnd); err != nil {
+			return fmt.Errorf("error seeking spool file for release asset %q: %w", asset.Name, err)
+		}
+
+		if !resumable || offset > asset.Size {
+			if err = f.Truncate(0); err == nil {
+				_, err = f.Seek(0, io.SeekStart)
+			}
+			if err != nil {
+				return fmt.Errorf("error truncating spool file for release asset %q: %w", asset.Name, err)
+			}
+			offset = 0
+		} else if offset > 0 && offset == asset.Size {
+			return nil
+		}
+
+		var rdr io.ReadCloser
+		if offset > 0 {
+			log.Info().Int64("offset", offset).Msg("Resuming download of release asset...")
+			rdr, err = rs.OpenAt(ctx, asset, offset)
+		} else {
+			rdr, err = src.Open(ctx, asset)
+		}
+
+		if err == nil {
+			var n int64
+			n, err = io.Copy(f, rdr)
+			_ = rdr.Close()
+			if err == nil && offset+n < asset.Size {
+				err = io.ErrUnexpectedEOF
+			}
+			if err == nil {
+				return nil
+			}
+		}
+
+		if ctx.Err() != nil || retry >= sp.retry.maxRetries {
+			return fmt.Errorf("error downloading release asset %q: %w", asset.Name, err)
+		}
+
+		wait := sp.retry.backoff(retry+1, nil)
+
+		log.Warn().Err(err).Dur("wait", wait).Msgf("Download failed, retrying (%d of %d)...", retry+1, sp.retry.maxRetries)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("error downloading release asset %q: %w", asset.Name, err)
+		case <-timer.C:
+		}
+	}
+}
+
+// verifyFileShasum checks the size and, if one is expected, the sha256 of a file
+func verifyFileShasum(fpath, expected string, size int64) error {
+	if expected == "" {
+		fi, err := os.Stat(fpath)
+		if err != nil {
+			return err
+		} else if fi.Size() != size {
+			return fmt.Errorf("file is %d bytes, expected %d", fi.Size(), size)
+		}
+		return nil
+	}
+
+	f, err := os.Open(fpath)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	_, err = io.Copy(io.Discard, newShasumVerifyingReader(f, expected, size))
+	return err
+}
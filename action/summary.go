@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dcarbone/go-tfc"
+	"github.com/hashicorp/go-multierror"
+)
+
+// PlatformResult is the outcome of publishing a single provider platform
+type PlatformResult struct {
+	OS         string
+	Arch       string
+	Filename   string
+	Shasum     string
+	PlatformID string
+	Duration   time.Duration
+
+	// Skipped is true if the binary had already been uploaded by a previous run
+	Skipped bool
+	Err     error
+}
+
+func (r PlatformResult) status() string {
+	if errors.Is(r.Err, errFailFast) {
+		return "cancelled"
+	} else if r.Err != nil {
+		return "failed"
+	} else if r.Skipped {
+		return "already uploaded"
+	}
+	return "uploaded"
+}
+
+// PublishSummary is reported to the workflow once a provider of the release has been published
+type PublishSummary struct {
+	// Target is empty unless TF_TARGETS is set
+	Target    string
+	Provider  string
+	KeyID     string
+	Source    string
+	Protocols []string
+	Platforms []PlatformResult
+
+	// Version is nil if publishing failed before the provider version was found or created
+	Version *ProviderVersionData
+	Err     error
+}
+
+// ProviderOutput is the entry of a provider in the "providers" step output
+type ProviderOutput struct {
+	Source      string            `json:"source"`
+	VersionID   string            `json:"version-id"`
+	Version     string            `json:"version"`
+	PlatformIDs map[string]string `json:"platform-ids"`
+}
+
+// registrySource returns the source address of the provider, as used in a required_providers block
+func registrySource(regClient *RegistryClient, cfg *Config) string {
+	host := "registry.terraform.io"
+	if cfg.TFRegistryName == TFRegistryNameDefault {
+		host = strings.TrimPrefix(tfc.DefaultAddress, "https://")
+		if u, err := url.Parse(regClient.address); err == nil && u.Host != "" {
+			host = u.Host
+		}
+	}
+	return fmt.Sprintf("%s/%s/%s", host, cfg.TFNamespace, cfg.TFProviderName)
+}
+
+// writePublishSummaries appends the markdown report of every provider to the job summary and sets the step outputs.
+// "targets" is set when TF_TARGETS is, "providers" unless there is more than one target, and the single provider
+// outputs only when one provider is published to one target.
+func writePublishSummaries(summaries []PublishSummary) error {
+	var (
+		sb      strings.Builder
+		outputs = make(map[string]map[string]ProviderOutput)
+		targets []string
+	)
+
+	for _, s := range summaries {
+		s.Platforms = s.sortedPlatforms()
+		sb.WriteString(s.markdown())
+		if _, ok := outputs[s.Target]; !ok {
+			outputs[s.Target] = make(map[string]ProviderOutput)
+			targets = append(targets, s.Target)
+		}
+		if s.Version != nil {
+			outputs[s.Target][s.Provider] = s.output()
+		}
+	}
+
+	if err := appendStepSummary(sb.String()); err != nil {
+		return err
+	}
+
+	if len(targets) > 0 && targets[0] != "" {
+		b, err := json.Marshal(outputs)
+		if err != nil {
+			return fmt.Errorf("error marshalling targets output: %w", err)
+		}
+		if err = setOutput("targets", string(b)); err != nil {
+			return err
+		}
+	}
+
+	if len(targets) != 1 {
+		return nil
+	}
+
+	b, err := json.Marshal(outputs[targets[0]])
+	if err != nil {
+		return fmt.Errorf("error marshalling providers output: %w", err)
+	}
+	if err = setOutput("providers", string(b)); err != nil {
+		return err
+	}
+
+	if len(summaries) != 1 || summaries[0].Version == nil {
+		return nil
+	}
+
+	out := outputs[targets[0]][summaries[0].Provider]
+	if b, err = json.Marshal(out.PlatformIDs); err != nil {
+		return fmt.Errorf("error marshalling platform ids: %w", err)
+	}
+
+	for _, o := range [][2]string{
+		{"version-id", out.VersionID},
+		{"version", out.Version},
+		{"platform-ids", string(b)},
+		{"source", out.Source},
+	} {
+		if err = setOutput(o[0], o[1]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeStatusTable prints the status of every platform of every provider published, with the error of those which
+// failed
+func writeStatusTable(w io.Writer, summaries []PublishSummary) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "PROVIDER\tTARGET\tPLATFORM\tSTATUS\tDURATION\tERROR")
+	for _, s := range summaries {
+		target := s.Target
+		if target == "" {
+			target = "-"
+		}
+		if len(s.Platforms) == 0 && s.Err != nil {
+			_, _ = fmt.Fprintf(tw, "%s\t%s\t-\tfailed\t-\t%s\n", s.Source, target, firstLine(s.Err))
+			continue
+		}
+		for _, p := range s.sortedPlatforms() {
+			duration, msg := "-", "-"
+			if p.Duration > 0 {
+				duration = p.Duration.Round(time.Millisecond).String()
+			}
+			if p.Err != nil {
+				msg = firstLine(p.Err)
+			}
+			_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", s.Source, target, platformKey(p.OS, p.Arch), p.status(), duration, msg)
+		}
+	}
+	_ = tw.Flush()
+}
+
+// firstLine returns the error message up to its first line break, as multierror messages span several lines
+func firstLine(err error) string {
+	msg := err.Error()
+	if errs := multierror.Append(nil, err).Errors; len(errs) == 1 {
+		msg = errs[0].Error()
+	}
+	line, _, _ := strings.Cut(strings.TrimSpace(msg), "\n")
+	return redact(line)
+}
+
+// sortedPlatforms returns the platform results ordered by os and arch, as they are collected in completion order
+func (s PublishSummary) sortedPlatforms() []PlatformResult {
+	out := append([]PlatformResult(nil), s.Platforms...)
+	sort.Slice(out, func(i, j int) bool {
+		return platformKey(out[i].OS, out[i].Arch) < platformKey(out[j].OS, out[j].Arch)
+	})
+	return out
+}
+
+func (s PublishSummary) output() ProviderOutput {
+	out := ProviderOutput{
+		Source:      s.Source,
+		VersionID:   s.Version.ID,
+		Version:     s.Version.Attributes.Version,
+		PlatformIDs: make(map[string]string, len(s.Platforms)),
+	}
+	for _, p := range s.Platforms {
+		if p.PlatformID != "" {
+			out.PlatformIDs[platformKey(p.OS, p.Arch)] = p.PlatformID
+		}
+	}
+	return out
+}
+
+func (s PublishSummary) markdown() string {
+	var sb strings.Builder
+
+	// without platform results there is nothing to tabulate, only the error
+	if s.Version == nil || (s.Err != nil && len(s.Platforms) == 0) {
+		_, _ = fmt.Fprintf(&sb, "## Failed to publish `%s`%s\n\n", s.Source, s.describeTarget())
+		if s.Err != nil {
+			_, _ = fmt.Fprintf(&sb, "```\n%s\n```\n\n", s.Err)
+		}
+		return sb.String()
+	}
+
+	version := s.Version.Attributes.Version
+
+	heading := "Published"
+	if s.Err != nil {
+		heading = "Partially published"
+	}
+
+	_, _ = fmt.Fprintf(&sb, "## %s `%s` %s%s\n\n", heading, s.Source, version, s.describeTarget())
+	_, _ = fmt.Fprintf(&sb, "Key ID `%s`, protocols `%s`.\n\n", s.KeyID, strings.Join(s.Protocols, ", "))
+
+	sb.WriteString("| Platform | Filename | SHA256 | Status | Upload Duration |\n")
+	sb.WriteString("|----------|----------|--------|--------|-----------------|\n")
+	for _, p := range s.Platforms {
+		duration := "-"
+		if p.Duration > 0 {
+			duration = p.Duration.Round(time.Millisecond).String()
+		}
+		_, _ = fmt.Fprintf(&sb, "| `%s` | `%s` | `%s` | %s | %s |\n", platformKey(p.OS, p.Arch), p.Filename, p.Shasum, p.status(), duration)
+	}
+
+	sb.WriteString("\n```hcl\nterraform {\n  required_providers {\n")
+	_, _ = fmt.Fprintf(&sb, "    %s = {\n      source  = %q\n      version = %q\n    }\n", s.Provider, s.Source, version)
+	sb.WriteString("  }\n}\n```\n\n")
+
+	return sb.String()
+}
+
+// describeTarget returns the heading suffix naming the target, if any
+func (s PublishSummary) describeTarget() string {
+	if s.Target == "" {
+		return ""
+	}
+	return fmt.Sprintf(" to target `%s`", s.Target)
+}
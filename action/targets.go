@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/rs/zerolog"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	EnvTFTargets = "TF_TARGETS"
+
+	// targetTokenEnvFormat is the environment variable a target's token is read from when not set in TF_TARGETS
+	targetTokenEnvFormat = "TF_TARGET_%s_TOKEN"
+)
+
+var TFTargetNameRe = regexp.MustCompile("^[A-Za-z0-9][A-Za-z0-9_-]*$")
+
+// RegistryTarget is a registry the release is published to.  Unset fields fall back to the top-level settings.
+type RegistryTarget struct {
+	Name         string `yaml:"name"`
+	Address      string `yaml:"address"`
+	Token        string `yaml:"token"`
+	Organization string `yaml:"organization"`
+	Registry     string `yaml:"registry"`
+	Namespace    string `yaml:"namespace"`
+	KeyID        string `yaml:"key-id"`
+
+	// tokenSource names where the token was taken from, for config explain
+	tokenSource string
+}
+
+// publishTarget is a target along with its config and registry client
+type publishTarget struct {
+	name      string
+	cfg       *Config
+	regClient *RegistryClient
+}
+
+// targetFields maps the top-level settings a target may override to the corresponding target field
+var targetFields = map[string]func(t *RegistryTarget) *string{
+	EnvTFAddress:          func(t *RegistryTarget) *string { return &t.Address },
+	EnvTFToken:            func(t *RegistryTarget) *string { return &t.Token },
+	EnvTFOrganizationName: func(t *RegistryTarget) *string { return &t.Organization },
+	EnvTFRegistryName:     func(t *RegistryTarget) *string { return &t.Registry },
+	EnvTFNamespace:        func(t *RegistryTarget) *string { return &t.Namespace },
+	EnvTFGPGKeyID:         func(t *RegistryTarget) *string { return &t.KeyID },
+}
+
+func targetTokenEnv(name string) string {
+	return fmt.Sprintf(targetTokenEnvFormat, strings.ToUpper(strings.ReplaceAll(name, "-", "_")))
+}
+
+// parseTargets parses TF_TARGETS, a YAML or JSON list of targets, filling in unset fields from the top-level settings.
+// A token may only be written inline when provided as an input or environment variable, never in the config file.
+func (c *Config) parseTargets() ([]RegistryTarget, error) {
+	var (
+		targets []RegistryTarget
+		out     []RegistryTarget
+		merr    error
+		seen    = make(map[string]struct{})
+	)
+
+	dec := yaml.NewDecoder(strings.NewReader(c.TFTargets))
+	dec.KnownFields(true)
+	if err := dec.Decode(&targets); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("error parsing targets: %w", err)
+	} else if len(targets) == 0 {
+		return nil, errors.New("at least one target must be listed")
+	}
+
+	for i := range targets {
+		t := &targets[i]
+
+		if !TFTargetNameRe.MatchString(t.Name) {
+			merr = multierror.Append(merr, fmt.Errorf("target name %q is not valid, it must match %q", t.Name, TFTargetNameRe.String()))
+			continue
+		} else if _, ok := seen[t.Name]; ok {
+			merr = multierror.Append(merr, fmt.Errorf("target %q is listed more than once", t.Name))
+			continue
+		}
+		seen[t.Name] = struct{}{}
+
+		if t.Token != "" {
+			if c.sources[EnvTFTargets] == SourceFile {
+				merr = multierror.Append(merr, fmt.Errorf("target %q must not contain a token in the config file, set %q instead", t.Name, targetTokenEnv(t.Name)))
+			}
+			t.tokenSource = "target"
+		} else if t.Token = strings.TrimSpace(os.Getenv(targetTokenEnv(t.Name))); t.Token != "" {
+			t.tokenSource = targetTokenEnv(t.Name)
+		} else if t.Token = c.TFToken; t.Token != "" {
+			t.tokenSource = EnvTFToken
+		}
+
+		for envName, field := range targetFields {
+			if envName != EnvTFToken && *field(t) == "" {
+				*field(t) = c.value(envName)
+			}
+		}
+
+		if t.Organization != "" && !TFOrganizationNameRe.MatchString(t.Organization) {
+			merr = multierror.Append(merr, fmt.Errorf("organization %q of target %q is not a valid name, it must match %q", t.Organization, t.Name, TFOrganizationNameRe.String()))
+		}
+		if t.Namespace != "" && !TFOrganizationNameRe.MatchString(t.Namespace) {
+			merr = multierror.Append(merr, fmt.Errorf("namespace %q of target %q is not a valid name, it must match %q", t.Namespace, t.Name, TFOrganizationNameRe.String()))
+		}
+		if t.Registry != "private" && t.Registry != "public" {
+			merr = multierror.Append(merr, fmt.Errorf("registry %q of target %q must be either \"private\" or \"public\"", t.Registry, t.Name))
+		}
+
+		out = append(out, *t)
+	}
+
+	return out, merr
+}
+
+// targets returns the registries the release is published to, a single one built from the top-level settings unless
+// TF_TARGETS is set
+func (c Config) targets() []RegistryTarget {
+	if len(c.tfTargets) > 0 {
+		return c.tfTargets
+	}
+	return []RegistryTarget{{
+		Address:      c.TFAddress,
+		Token:        c.TFToken,
+		Organization: c.TFOrganizationName,
+		Registry:     c.TFRegistryName,
+		Namespace:    c.TFNamespace,
+		KeyID:        c.TFGPGKeyID,
+	}}
+}
+
+// forTarget returns a copy of the config scoped to a single target, see forProvider
+func (c *Config) forTarget(t RegistryTarget) *Config {
+	tc := *c
+	tc.TFAddress = t.Address
+	tc.TFToken = t.Token
+	tc.TFOrganizationName = t.Organization
+	tc.TFRegistryName = t.Registry
+	tc.TFNamespace = t.Namespace
+	tc.TFGPGKeyID = t.KeyID
+	return &tc
+}
+
+// checkRequired returns an error if a required setting has no value.  For targeted commands, settings a target may
+// override are required of every target instead.
+func (c *Config) checkRequired(envName string, targeted bool) []error {
+	field, ok := targetFields[envName]
+	if !ok || !targeted || len(c.tfTargets) == 0 {
+		if c.value(envName) == "" {
+			return []error{fmt.Errorf("missing required %s", settingName(envName))}
+		}
+		return nil
+	}
+
+	var errs []error
+	for _, t := range c.tfTargets {
+		if *field(&t) != "" {
+			continue
+		}
+		// the namespace may instead be given for every provider
+		if envName == EnvTFNamespace && c.providersHaveNamespaces() {
+			continue
+		}
+		if envName == EnvTFToken {
+			errs = append(errs, fmt.Errorf("target %q has no token, set %q or %s", t.Name, targetTokenEnv(t.Name), settingName(envName)))
+		} else {
+			errs = append(errs, fmt.Errorf("target %q is missing %q, set it in %q or with %s", t.Name, targetFieldName(envName), EnvTFTargets, settingName(envName)))
+		}
+	}
+	return errs
+}
+
+// targetFieldName returns the TF_TARGETS key overriding a top-level setting
+func targetFieldName(envName string) string {
+	switch envName {
+	case EnvTFOrganizationName:
+		return "organization"
+	case EnvTFRegistryName:
+		return "registry"
+	case EnvTFGPGKeyID:
+		return "key-id"
+	default:
+		return strings.ToLower(strings.TrimPrefix(envName, "TF_"))
+	}
+}
+
+// newPublishTargets constructs a registry client per target.  Read-only clients are used by plan.
+func newPublishTargets(ctx context.Context, log zerolog.Logger, cfg *Config, readOnly bool) ([]publishTarget, error) {
+	var out []publishTarget
+
+	for _, t := range cfg.targets() {
+		pt := publishTarget{
+			name: t.Name,
+			cfg:  cfg.forTarget(t),
+		}
+
+		regClient, err := NewRegistryClient(ctx, pt.withTarget(log), pt.cfg)
+		if err != nil {
+			return nil, fmt.Errorf("error constructing RegistryClient%s: %w", pt.describe(), err)
+		}
+		regClient.readOnly = readOnly
+		pt.regClient = regClient
+
+		out = append(out, pt)
+	}
+
+	return out, nil
+}
+
+// describe returns the target name for use in messages, empty when publishing to the top-level settings only
+func (pt publishTarget) describe() string {
+	if pt.name == "" {
+		return ""
+	}
+	return fmt.Sprintf(" for target %q", pt.name)
+}
+
+// wrap prefixes err with the target name, if the target has one
+func (pt publishTarget) wrap(err error) error {
+	if pt.name == "" {
+		return err
+	}
+	return fmt.Errorf("target %q: %w", pt.name, err)
+}
+
+// withTarget adds the target name to log, if the target has one
+func (pt publishTarget) withTarget(log zerolog.Logger) zerolog.Logger {
+	if pt.name == "" {
+		return log
+	}
+	return log.With().Str("target", pt.name).Logger()
+}
@@ -1,8 +1,14 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
 	"io"
 	"io/ioutil"
+	"strings"
 )
 
 func drainReader(r io.Reader) {
@@ -14,3 +20,75 @@ func drainReader(r io.Reader) {
 		_ = rc.Close()
 	}
 }
+
+// platformKey produces the key used to uniquely identify a single os / arch combination of a provider version
+func platformKey(os, arch string) string {
+	return fmt.Sprintf("%s_%s", os, arch)
+}
+
+// sameStrings returns true if both slices contain the same values, regardless of order
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	counts := make(map[string]int, len(a))
+	for _, s := range a {
+		counts[s]++
+	}
+	for _, s := range b {
+		if counts[s]--; counts[s] < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// shasumVerifyingReader computes the sha256 of everything read through it.  When the expected size is known, the
+// final chunk is only handed to the caller once the full content has been verified, so a consumer never receives a
+// complete but mismatched body.
+type shasumVerifyingReader struct {
+	r        io.Reader
+	h        hash.Hash
+	expected string
+	size     int64
+	read     int64
+	err      error
+}
+
+func newShasumVerifyingReader(r io.Reader, expected string, size int64) *shasumVerifyingReader {
+	vr := shasumVerifyingReader{
+		r:        r,
+		h:        sha256.New(),
+		expected: strings.ToLower(expected),
+		size:     size,
+	}
+	return &vr
+}
+
+func (vr *shasumVerifyingReader) Read(p []byte) (int, error) {
+	if vr.err != nil {
+		return 0, vr.err
+	}
+
+	n, err := vr.r.Read(p)
+	vr.h.Write(p[:n])
+	vr.read += int64(n)
+
+	if vr.size > 0 && vr.read > vr.size {
+		vr.err = fmt.Errorf("read %d bytes, more than the expected %d", vr.read, vr.size)
+		return 0, vr.err
+	}
+
+	if (vr.size > 0 && vr.read == vr.size) || errors.Is(err, io.EOF) {
+		if vr.size > 0 && vr.read != vr.size {
+			vr.err = fmt.Errorf("read %d bytes, expected %d: content truncated", vr.read, vr.size)
+			return 0, vr.err
+		}
+		if actual := hex.EncodeToString(vr.h.Sum(nil)); actual != vr.expected {
+			vr.err = fmt.Errorf("shasum mismatch: expected %q, computed %q", vr.expected, actual)
+			return 0, vr.err
+		}
+	}
+
+	return n, err
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestShasumVerifyingReader(t *testing.T) {
+	data := []byte("terraform-provider-foo_1.2.3_linux_amd64.zip contents")
+	sum := sha256.Sum256(data)
+	shasum := hex.EncodeToString(sum[:])
+	badShasum := strings.Repeat("0", 64)
+
+	tests := []struct {
+		name     string
+		body     []byte
+		expected string
+		size     int64
+		err      string
+	}{
+		{name: "match", body: data, expected: shasum, size: int64(len(data))},
+		{name: "match-uppercase", body: data, expected: strings.ToUpper(shasum), size: int64(len(data))},
+		{name: "match-unknown-size", body: data, expected: shasum},
+		{name: "mismatch", body: data, expected: badShasum, size: int64(len(data)), err: "shasum mismatch"},
+		{name: "mismatch-unknown-size", body: data, expected: badShasum, err: "shasum mismatch"},
+		{name: "truncated", body: data[:10], expected: shasum, size: int64(len(data)), err: "content truncated"},
+		{name: "oversized", body: append(append([]byte(nil), data...), 'x'), expected: shasum, size: int64(len(data)), err: "more than the expected"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// one byte at a time, so the final chunk is distinct from the rest
+			vr := newShasumVerifyingReader(iotest.OneByteReader(bytes.NewReader(tt.body)), tt.expected, tt.size)
+			got, err := io.ReadAll(vr)
+
+			if tt.err == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if !bytes.Equal(got, tt.body) {
+					t.Fatalf("read %q, expected %q", got, tt.body)
+				}
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("expected error containing %q, got %v", tt.err, err)
+			}
+			// with a known size the final bytes are withheld, so a consumer never sees a complete mismatched body
+			if tt.expected == badShasum && tt.size > 0 && int64(len(got)) >= tt.size {
+				t.Fatalf("read %d bytes before failing, expected fewer than %d", len(got), tt.size)
+			}
+			// the error sticks, nothing more is handed out
+			if n, rerr := vr.Read(make([]byte, 8)); n != 0 || rerr == nil {
+				t.Fatalf("expected sticky error, got n=%d err=%v", n, rerr)
+			}
+		})
+	}
+}